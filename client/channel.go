@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/colevoss/awesome-go-realtime/server"
+)
+
+// Event is a ServerEvent received on a channel. Channel is the concrete
+// channel it was emitted on, which differs from the subscription's name for
+// pattern subscriptions.
+type Event struct {
+	Channel string
+	Name    string
+	Data    json.RawMessage
+	Seq     uint64
+}
+
+func (e *Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+type Handler func(*Event)
+
+// Channel is a subscription to a single server channel.
+type Channel struct {
+	client *Client
+	name   string
+	data   json.RawMessage
+
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+	lastSeq  uint64
+}
+
+func newChannel(client *Client, name string, data json.RawMessage) *Channel {
+	return &Channel{
+		client:   client,
+		name:     name,
+		data:     data,
+		handlers: make(map[string][]Handler),
+	}
+}
+
+func (ch *Channel) Name() string {
+	return ch.name
+}
+
+// Sends Subscribe, asking for any retained messages missed since the last
+// sequence number seen when resubscribing. A non-empty ref asks the server
+// to confirm the subscription.
+func (ch *Channel) subscribe(ref string) error {
+	msg := ch.newMessage(server.Subscribe, "", ch.data)
+	msg.Ref = ref
+
+	ch.mu.RLock()
+	if ch.lastSeq > 0 {
+		since := ch.lastSeq
+		msg.Since = &since
+	}
+	ch.mu.RUnlock()
+
+	return ch.client.write(msg)
+}
+
+// On registers fn for every ServerEvent named event on this channel.
+// Handlers for every channel run in order on a single goroutine, separate
+// from the one reading messages, so they may call Request or Subscribe.
+// Events wait while a handler runs.
+func (ch *Channel) On(event string, fn Handler) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.handlers[event] = append(ch.handlers[event], fn)
+}
+
+// Push sends a ClientEvent without waiting for a reply.
+func (ch *Channel) Push(event string, data interface{}) error {
+	raw, err := json.Marshal(data)
+
+	if err != nil {
+		return err
+	}
+
+	return ch.client.write(ch.newMessage(server.ClientEvent, event, raw))
+}
+
+// Request sends a ClientEvent carrying a ref and waits for the server's
+// reply. Errors returned by the handler come back as a *server.ServerError.
+func (ch *Channel) Request(ctx context.Context, event string, data interface{}) (json.RawMessage, error) {
+	raw, err := json.Marshal(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ref, replies := ch.client.newRef()
+	msg := ch.newMessage(server.ClientEvent, event, raw)
+	msg.Ref = ref
+
+	if err := ch.client.write(msg); err != nil {
+		ch.client.forget(ref)
+		return nil, err
+	}
+
+	select {
+	case r := <-replies:
+		return r.data, r.err
+	case <-ctx.Done():
+		ch.client.forget(ref)
+		return nil, ctx.Err()
+	}
+}
+
+// Leave unsubscribes from the channel. It is not resubscribed on reconnect.
+func (ch *Channel) Leave() error {
+	ch.client.removeChannel(ch.name)
+
+	return ch.client.write(ch.newMessage(server.Unsubscribe, "", nil))
+}
+
+func (ch *Channel) dispatch(msg *incoming) {
+	ch.mu.Lock()
+	if msg.Pattern == "" && msg.Seq > ch.lastSeq {
+		ch.lastSeq = msg.Seq
+	}
+
+	handlers := ch.handlers[msg.Event]
+	ch.mu.Unlock()
+
+	event := &Event{Channel: msg.Channel, Name: msg.Event, Data: msg.Data, Seq: msg.Seq}
+
+	for _, fn := range handlers {
+		fn := fn
+		ch.client.queue(func() { fn(event) })
+	}
+}
+
+func (ch *Channel) newMessage(t server.ConnectionEvent, event string, data json.RawMessage) *server.ClientMessage {
+	return &server.ClientMessage{
+		Message: server.Message{
+			Type:    t,
+			Channel: ch.name,
+		},
+		Event:   event,
+		RawData: data,
+	}
+}
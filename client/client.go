@@ -0,0 +1,451 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/colevoss/awesome-go-realtime/server"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait = 10 * time.Second
+	pongWait  = 60 * time.Second
+)
+
+var (
+	ErrClosed       = errors.New("client: closed")
+	ErrDisconnected = errors.New("client: disconnected")
+)
+
+type Config struct {
+	Header http.Header
+	Dialer *websocket.Dialer
+
+	// Backoff between reconnect attempts doubles from MinBackoff up to
+	// MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Logger receives failed reconnects and malformed messages. Defaults to
+	// server.NopLogger.
+	Logger server.Logger
+}
+
+func (c Config) withDefaults() Config {
+	if c.Dialer == nil {
+		c.Dialer = websocket.DefaultDialer
+	}
+
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = 100 * time.Millisecond
+	}
+
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 10 * time.Second
+	}
+
+	if c.Logger == nil {
+		c.Logger = server.NopLogger
+	}
+
+	return c
+}
+
+// Client is a connection to a RealtimeServer. It reconnects with backoff
+// whenever the connection drops and resubscribes to its channels.
+type Client struct {
+	url    string
+	config Config
+
+	mu       sync.Mutex
+	conn     *websocket.Conn
+	channels map[string]*Channel
+	pending  map[string]chan *reply
+	nextRef  uint64
+	onError  func(*server.ServerError)
+	closed   bool
+
+	writeMu sync.Mutex
+
+	// handlers queued by the read goroutine, run in order by handle
+	handlersMu sync.Mutex
+	handlers   []func()
+	ready      chan struct{}
+
+	done chan struct{}
+}
+
+// incoming is the union of every message the server sends.
+type incoming struct {
+	Type    server.ConnectionEvent `json:"type"`
+	Channel string                 `json:"channel"`
+	Event   string                 `json:"event"`
+	Data    json.RawMessage        `json:"data"`
+	Seq     uint64                 `json:"seq"`
+	Ref     string                 `json:"ref"`
+	Pattern string                 `json:"pattern"`
+	Error   string                 `json:"error"`
+}
+
+type reply struct {
+	data json.RawMessage
+	err  error
+}
+
+// Dial connects to the realtime endpoint at url.
+func Dial(ctx context.Context, url string, config Config) (*Client, error) {
+	c := &Client{
+		url:      url,
+		config:   config.withDefaults(),
+		channels: make(map[string]*Channel),
+		pending:  make(map[string]chan *reply),
+		ready:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	conn, err := c.dial(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.conn = conn
+
+	go c.run(conn)
+	go c.handle()
+
+	return c, nil
+}
+
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	conn, _, err := c.config.Dialer.DialContext(ctx, c.url, c.config.Header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+
+		return err
+	})
+
+	return conn, nil
+}
+
+// Reads from conn until it fails, then reconnects until the client is closed.
+func (c *Client) run(conn *websocket.Conn) {
+	for {
+		c.read(conn)
+		conn.Close()
+		c.failPending(ErrDisconnected)
+
+		var ok bool
+
+		if conn, ok = c.reconnect(); !ok {
+			return
+		}
+	}
+}
+
+func (c *Client) read(conn *websocket.Conn) {
+	for {
+		_, bytes, err := conn.ReadMessage()
+
+		if err != nil {
+			return
+		}
+
+		var msg incoming
+
+		if err := json.Unmarshal(bytes, &msg); err != nil {
+			c.config.Logger.Log(server.LevelWarn, "malformed message", server.Field{Key: "error", Value: err})
+			continue
+		}
+
+		c.dispatch(&msg)
+	}
+}
+
+func (c *Client) dispatch(msg *incoming) {
+	switch msg.Type {
+	case server.ServerReply:
+		c.resolve(msg.Ref, &reply{data: msg.Data})
+
+	case server.ServerErrorMessageType:
+		serverErr := &server.ServerError{Type: msg.Type, Msg: msg.Error, Ref: msg.Ref}
+		json.Unmarshal(msg.Data, &serverErr.Data)
+
+		if msg.Ref != "" && c.resolve(msg.Ref, &reply{err: serverErr}) {
+			return
+		}
+
+		c.mu.Lock()
+		onError := c.onError
+		c.mu.Unlock()
+
+		if onError != nil {
+			c.queue(func() { onError(serverErr) })
+		}
+
+	case server.ServerEvent:
+		name := msg.Channel
+
+		// Events delivered for a pattern subscription name the concrete channel
+		if msg.Pattern != "" {
+			name = msg.Pattern
+		}
+
+		c.mu.Lock()
+		channel, ok := c.channels[name]
+		c.mu.Unlock()
+
+		if ok {
+			channel.dispatch(msg)
+		}
+	}
+}
+
+// Queues fn to run on the handler goroutine. Handlers run off the read
+// goroutine so they may wait on requests, whose replies it reads.
+func (c *Client) queue(fn func()) {
+	c.handlersMu.Lock()
+	c.handlers = append(c.handlers, fn)
+	c.handlersMu.Unlock()
+
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Runs queued handlers in order until the client is closed.
+func (c *Client) handle() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.ready:
+		}
+
+		c.handlersMu.Lock()
+		handlers := c.handlers
+		c.handlers = nil
+		c.handlersMu.Unlock()
+
+		for _, fn := range handlers {
+			fn()
+		}
+	}
+}
+
+func (c *Client) reconnect() (*websocket.Conn, bool) {
+	backoff := c.config.MinBackoff
+
+	for {
+		select {
+		case <-c.done:
+			return nil, false
+		case <-time.After(backoff):
+		}
+
+		conn, err := c.dial(context.Background())
+
+		if err != nil {
+			c.config.Logger.Log(server.LevelWarn, "reconnect failed", server.Field{Key: "error", Value: err})
+
+			if backoff *= 2; backoff > c.config.MaxBackoff {
+				backoff = c.config.MaxBackoff
+			}
+
+			continue
+		}
+
+		c.mu.Lock()
+
+		if c.closed {
+			c.mu.Unlock()
+			conn.Close()
+			return nil, false
+		}
+
+		c.conn = conn
+		channels := make([]*Channel, 0, len(c.channels))
+
+		for _, channel := range c.channels {
+			channels = append(channels, channel)
+		}
+		c.mu.Unlock()
+
+		for _, channel := range channels {
+			if err := channel.subscribe(""); err != nil {
+				c.config.Logger.Log(server.LevelWarn, "resubscribe failed", server.Field{Key: "channel", Value: channel.name}, server.Field{Key: "error", Value: err})
+			}
+		}
+
+		return conn, true
+	}
+}
+
+func (c *Client) write(msg *server.ClientMessage) error {
+	c.mu.Lock()
+	conn, closed := c.conn, c.closed
+	c.mu.Unlock()
+
+	if closed {
+		return ErrClosed
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+	return conn.WriteJSON(msg)
+}
+
+func (c *Client) newRef() (string, chan *reply) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextRef++
+	ref := strconv.FormatUint(c.nextRef, 10)
+	ch := make(chan *reply, 1)
+	c.pending[ref] = ch
+
+	return ref, ch
+}
+
+func (c *Client) forget(ref string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, ref)
+}
+
+func (c *Client) resolve(ref string, r *reply) bool {
+	c.mu.Lock()
+	ch, ok := c.pending[ref]
+	delete(c.pending, ref)
+	c.mu.Unlock()
+
+	if ok {
+		ch <- r
+	}
+
+	return ok
+}
+
+func (c *Client) failPending(err error) {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]chan *reply)
+	c.mu.Unlock()
+
+	for _, ch := range pending {
+		ch <- &reply{err: err}
+	}
+}
+
+// OnError sets the handler for server errors that do not answer a request,
+// such as a rejected Subscribe. It runs on the same goroutine as Channel
+// handlers.
+func (c *Client) OnError(fn func(*server.ServerError)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onError = fn
+}
+
+// Subscribe joins the named channel and waits for the server to confirm it.
+// The name may be a pattern such as orders.*.status when the server allows
+// pattern subscriptions; events then arrive from every matching channel.
+// data is sent with the Subscribe message, for example as presence metadata,
+// and may be nil. A rejected subscription returns a *server.ServerError.
+func (c *Client) Subscribe(ctx context.Context, name string, data interface{}) (*Channel, error) {
+	raw, err := json.Marshal(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	channel := newChannel(c, name, raw)
+
+	c.mu.Lock()
+
+	if _, exists := c.channels[name]; exists {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("client: already subscribed to %s", name)
+	}
+
+	c.channels[name] = channel
+	c.mu.Unlock()
+
+	ref, replies := c.newRef()
+
+	if err := channel.subscribe(ref); err != nil {
+		c.forget(ref)
+		c.removeChannel(name)
+		return nil, err
+	}
+
+	select {
+	case r := <-replies:
+		if r.err != nil {
+			c.removeChannel(name)
+			return nil, r.err
+		}
+	case <-ctx.Done():
+		c.forget(ref)
+		c.removeChannel(name)
+		return nil, ctx.Err()
+	}
+
+	return channel, nil
+}
+
+func (c *Client) removeChannel(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.channels, name)
+}
+
+// Close disconnects from the server without reconnecting.
+func (c *Client) Close() error {
+	c.mu.Lock()
+
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	conn := c.conn
+	close(c.done)
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(writeWait),
+	)
+	c.writeMu.Unlock()
+
+	return conn.Close()
+}
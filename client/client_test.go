@@ -0,0 +1,298 @@
+package client
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colevoss/awesome-go-realtime/server"
+)
+
+func newTestServer(t *testing.T) (*server.RealtimeServer, *httptest.Server, string) {
+	t.Helper()
+
+	rts := server.NewRealtimeServer()
+	cf := server.NewChannelFactory("room.{id}")
+	cf.HandleReply("echo", func(ctx context.Context, e *server.Event) (interface{}, error) {
+		var v interface{}
+
+		if err := e.Data(&v); err != nil {
+			return nil, err
+		}
+
+		e.Emit("echoed", v)
+
+		return v, nil
+	})
+	rts.RegisterChannelFactory(cf)
+
+	srv := httptest.NewServer(rts)
+
+	return rts, srv, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestClientRequestAndEvents(t *testing.T) {
+	_, srv, url := newTestServer(t)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, url, Config{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	channel, err := c.Subscribe(ctx, "room.1", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	echoed := make(chan string, 1)
+	channel.On("echoed", func(e *Event) {
+		var s string
+		e.Decode(&s)
+		echoed <- s
+	})
+
+	data, err := channel.Request(ctx, "echo", "hello")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != `"hello"` {
+		t.Errorf("expected reply \"hello\", got %s", data)
+	}
+
+	select {
+	case s := <-echoed:
+		if s != "hello" {
+			t.Errorf("expected echoed event hello, got %s", s)
+		}
+	case <-ctx.Done():
+		t.Fatal("echoed event never received")
+	}
+
+	if _, err := channel.Request(ctx, "missing", nil); err == nil {
+		t.Error("request to missing handler should fail")
+	}
+}
+
+func TestClientReconnectResubscribes(t *testing.T) {
+	rts, srv, url := newTestServer(t)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, url, Config{MinBackoff: 10 * time.Millisecond})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	channel, _ := c.Subscribe(ctx, "room.1", nil)
+
+	received := make(chan struct{}, 1)
+	channel.On("again", func(e *Event) {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+	})
+
+	c.mu.Lock()
+	c.conn.Close()
+	c.mu.Unlock()
+
+	// Only reaches the client once it has subscribed to room.1 again
+	for {
+		rts.Publish("room.1", "again", nil)
+
+		select {
+		case <-received:
+			return
+		case <-ctx.Done():
+			t.Fatal("client never resubscribed")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestClientClosesFailedConnection(t *testing.T) {
+	rts, srv, url := newTestServer(t)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, url, Config{MinBackoff: 10 * time.Millisecond})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	// Fails the read as a missed pong would, leaving the socket open
+	c.mu.Lock()
+	failed := c.conn
+	c.conn.SetReadDeadline(time.Now())
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		reconnected := c.conn != failed
+		c.mu.Unlock()
+
+		if reconnected && len(rts.Connections()) == 1 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatalf("failed connection was not closed, server has %d connections", len(rts.Connections()))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestClientHandlersMayRequest(t *testing.T) {
+	_, srv, url := newTestServer(t)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, url, Config{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	channel, err := c.Subscribe(ctx, "room.1", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replies := make(chan error, 1)
+	channel.On("echoed", func(e *Event) {
+		var s string
+
+		if e.Decode(&s); s != "first" {
+			return
+		}
+
+		_, err := channel.Request(ctx, "echo", "second")
+		replies <- err
+	})
+
+	if _, err := channel.Request(ctx, "echo", "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-replies; err != nil {
+		t.Errorf("request from a handler should be answered, got %v", err)
+	}
+}
+
+func TestClientPatternSubscribe(t *testing.T) {
+	rts := server.NewRealtimeServer(server.WithPatternAuthorizer(func(conn *server.Connection, pattern string) error {
+		if pattern != "orders.*.status" {
+			return server.Reject(server.RejectForbidden, "pattern not allowed")
+		}
+
+		return nil
+	}))
+	rts.RegisterChannelFactory(server.NewChannelFactory("orders.{id}.status"))
+
+	srv := httptest.NewServer(rts)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), Config{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if _, err := c.Subscribe(ctx, "orders.*.*", nil); err == nil {
+		t.Fatal("patterns the authorizer refuses should be rejected")
+	}
+
+	channel, err := c.Subscribe(ctx, "orders.*.status", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan *Event, 1)
+	channel.On("shipped", func(e *Event) {
+		received <- e
+	})
+
+	rts.Publish("orders.7.status", "shipped", nil)
+
+	select {
+	case e := <-received:
+		if e.Channel != "orders.7.status" {
+			t.Errorf("event should name its concrete channel, got %s", e.Channel)
+		}
+	case <-ctx.Done():
+		t.Fatal("pattern event never received")
+	}
+}
+
+func TestClientLogsReconnectFailures(t *testing.T) {
+	_, srv, url := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	logged := make(chan string, 16)
+	logger := server.LoggerFunc(func(level server.Level, msg string, fields ...server.Field) {
+		select {
+		case logged <- msg:
+		default:
+		}
+	})
+
+	c, err := Dial(ctx, url, Config{MinBackoff: 10 * time.Millisecond, Logger: logger})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	srv.Close()
+
+	c.mu.Lock()
+	c.conn.Close()
+	c.mu.Unlock()
+
+	select {
+	case msg := <-logged:
+		if msg != "reconnect failed" {
+			t.Errorf("expected reconnect failed, got %q", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("failed reconnect was never logged")
+	}
+}
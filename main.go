@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"time"
 
 	"github.com/colevoss/awesome-go-realtime/server"
 	"github.com/colevoss/awesome-go-realtime/test"
@@ -14,6 +17,7 @@ var addr = "localhost:8080"
 
 func run() error {
 	rtServer := server.NewRealtimeServer()
+	rtServer.Use(server.Recover())
 
 	otherCf := server.NewChannelFactory("test.channel")
 
@@ -27,12 +31,46 @@ func run() error {
 
 	test := &test.Test{}
 
-	rtServer.RegisterChannel("test.{id}.channel", test)
-	rtServer.RegisterChannelFactory(otherCf)
+	if err := rtServer.RegisterChannel("test.{id}.channel", test); err != nil {
+		return err
+	}
+
+	if err := rtServer.RegisterChannelFactory(otherCf); err != nil {
+		return err
+	}
 
 	http.Handle("/rt", rtServer)
+	http.Handle("/metrics", rtServer.MetricsHandler())
+
+	httpServer := &http.Server{Addr: addr}
+
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt)
+		<-stop
+
+		log.Println("Shutting down server")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Websocket connections are hijacked, so they must be drained before
+		// the http server stops
+		if err := rtServer.Shutdown(ctx); err != nil {
+			log.Println("Realtime shutdown:", err)
+		}
+
+		httpServer.Shutdown(ctx)
+	}()
+
 	log.Println("Starting server:", addr)
-	return http.ListenAndServe(addr, nil)
+	err := httpServer.ListenAndServe()
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
 }
 
 func main() {
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// ErrCodeChannelClosed is sent to a channel's subscribers when the server
+// closes it.
+const ErrCodeChannelClosed = "channel_closed"
+
+const disconnectReason = "disconnected by server"
+
+type ChannelInfo struct {
+	Name        string            `json:"name"`
+	Path        string            `json:"path"`
+	Params      map[string]string `json:"params"`
+	Subscribers int               `json:"subscribers"`
+}
+
+type ConnectionInfo struct {
+	Id       uuid.UUID `json:"id"`
+	Identity string    `json:"identity,omitempty"`
+	Codec    string    `json:"codec"`
+	Channels []string  `json:"channels"`
+	Queued   int       `json:"queued"`
+	Dropped  uint64    `json:"dropped"`
+}
+
+type FactoryInfo struct {
+	Path     string   `json:"path"`
+	Handlers []string `json:"handlers"`
+	Presence bool     `json:"presence"`
+}
+
+// Channels lists the channels open on this node.
+func (s *RealtimeServer) Channels() []ChannelInfo {
+	s.Hub.mu.RLock()
+	channels := make([]*Channel, 0, len(s.Hub.channelsCache))
+
+	for _, channel := range s.Hub.channelsCache {
+		channels = append(channels, channel)
+	}
+	s.Hub.mu.RUnlock()
+
+	infos := make([]ChannelInfo, len(channels))
+
+	for i, channel := range channels {
+		params := make(map[string]string)
+
+		if channel.Params != nil {
+			for _, param := range *channel.Params {
+				params[param.Key] = param.Value
+			}
+		}
+
+		infos[i] = ChannelInfo{
+			Name:        channel.Name,
+			Path:        channel.Path,
+			Params:      params,
+			Subscribers: channel.SubscriberCount(),
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	return infos
+}
+
+// Connections lists the connections open on this node and the channels they
+// are subscribed to.
+func (s *RealtimeServer) Connections() []ConnectionInfo {
+	s.connsMu.Lock()
+	conns := make([]*Connection, 0, len(s.connections))
+
+	for conn := range s.connections {
+		conns = append(conns, conn)
+	}
+	s.connsMu.Unlock()
+
+	infos := make([]ConnectionInfo, len(conns))
+
+	for i, conn := range conns {
+		conn.mu.RLock()
+		channels := make([]string, 0, len(conn.channels))
+
+		for channel := range conn.channels {
+			channels = append(channels, channel.Name)
+		}
+		conn.mu.RUnlock()
+
+		sort.Strings(channels)
+
+		infos[i] = ConnectionInfo{
+			Id:       conn.Id,
+			Codec:    conn.codec.Name(),
+			Channels: channels,
+			Queued:   conn.send.len(),
+			Dropped:  conn.Dropped(),
+		}
+
+		if conn.Identity != nil {
+			infos[i].Identity = fmt.Sprint(conn.Identity)
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Id.String() < infos[j].Id.String() })
+
+	return infos
+}
+
+// Factories lists the registered channel factories and their handlers,
+// including the join and leave hooks.
+func (s *RealtimeServer) Factories() []FactoryInfo {
+	s.Hub.mu.RLock()
+	factories := make([]*ChannelFactory, 0, len(s.Hub.channelFactories))
+
+	for _, cf := range s.Hub.channelFactories {
+		factories = append(factories, cf)
+	}
+	s.Hub.mu.RUnlock()
+
+	infos := make([]FactoryInfo, len(factories))
+
+	for i, cf := range factories {
+		cf.mu.Lock()
+		handlers := make([]string, 0, len(cf.handlers))
+
+		for event := range cf.handlers {
+			handlers = append(handlers, hookName(event))
+		}
+
+		infos[i] = FactoryInfo{Path: cf.path, Handlers: handlers, Presence: cf.presence}
+		cf.mu.Unlock()
+
+		sort.Strings(handlers)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Path < infos[j].Path })
+
+	return infos
+}
+
+// Disconnect flushes and closes the connection with id, returning whether it
+// was open on this node.
+func (s *RealtimeServer) Disconnect(id uuid.UUID) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	for conn := range s.connections {
+		if conn.Id == id {
+			conn.log(LevelInfo, "disconnecting connection")
+			conn.shutdown(websocket.ClosePolicyViolation, disconnectReason)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// CloseChannel unsubscribes every connection from the channel on this node,
+// running its Leave hooks, after sending each a ServerError with code
+// channel_closed. Returns whether the channel was open.
+func (s *RealtimeServer) CloseChannel(name string) bool {
+	channel, ok := s.Hub.findChannel(name)
+
+	if !ok {
+		return false
+	}
+
+	channel.log(LevelInfo, "closing channel")
+
+	channel.history.mu.Lock()
+	conns := make([]*Connection, 0, len(channel.connections))
+
+	for conn := range channel.connections {
+		conns = append(conns, conn)
+	}
+	channel.history.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.handleError(NewServerError("Channel closed", ServerErrorFields{
+			"channel": channel.Name,
+			"code":    ErrCodeChannelClosed,
+		}))
+
+		channel.removeConnection(conn.ctx, &Event{Conn: conn, Channel: channel})
+	}
+
+	return true
+}
+
+// AdminHandler serves a JSON introspection API:
+//
+//	GET    /channels          open channels with params and subscriber counts
+//	DELETE /channels/{name}   close a channel
+//	GET    /connections       open connections and their channels
+//	DELETE /connections/{id}  disconnect a connection
+//	GET    /factories         channel factory paths and handlers
+//
+// It is not authenticated; mount it with http.StripPrefix behind your own
+// authentication and away from public traffic.
+func (s *RealtimeServer) AdminHandler() http.Handler {
+	return http.HandlerFunc(s.serveAdmin)
+}
+
+func (s *RealtimeServer) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	resource, name, _ := strings.Cut(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && name == "" && resource == "channels":
+		writeJSON(w, s.Channels())
+	case r.Method == http.MethodGet && name == "" && resource == "connections":
+		writeJSON(w, s.Connections())
+	case r.Method == http.MethodGet && name == "" && resource == "factories":
+		writeJSON(w, s.Factories())
+
+	case r.Method == http.MethodDelete && name != "" && resource == "channels":
+		if !s.CloseChannel(name) {
+			http.Error(w, "channel not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodDelete && name != "" && resource == "connections":
+		id, err := uuid.Parse(name)
+
+		if err != nil || !s.Disconnect(id) {
+			http.Error(w, "connection not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
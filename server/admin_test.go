@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func adminRequest(t *testing.T, rts *RealtimeServer, method string, path string, v interface{}) int {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	rts.AdminHandler().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+
+	return rec.Code
+}
+
+func TestAdminHandler(t *testing.T) {
+	rts := NewRealtimeServer()
+	cf := NewChannelFactory("room.{id}")
+
+	cf.Join(func(ctx context.Context, e *Event) error {
+		return nil
+	})
+	cf.Handle("ping", func(ctx context.Context, e *Event) error {
+		return nil
+	})
+	rts.RegisterChannelFactory(cf)
+
+	clients := []*websocket.Conn{dialTestServer(t, rts), dialTestServer(t, rts)}
+
+	for i, ws := range clients {
+		channel := []string{"room.1", "room.2"}[i]
+		ws.WriteJSON(&ClientMessage{Message: Message{Type: Subscribe, Channel: channel}, Ref: "1"})
+
+		if _, _, err := ws.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var factories []FactoryInfo
+	adminRequest(t, rts, "GET", "/factories", &factories)
+
+	if len(factories) != 1 || factories[0].Path != "room.{id}" || len(factories[0].Handlers) != 2 || factories[0].Handlers[0] != "Join" || factories[0].Handlers[1] != "ping" {
+		t.Errorf("unexpected factories %+v", factories)
+	}
+
+	var channels []ChannelInfo
+	adminRequest(t, rts, "GET", "/channels", &channels)
+
+	if len(channels) != 2 || channels[0].Name != "room.1" || channels[0].Params["id"] != "1" || channels[0].Subscribers != 1 {
+		t.Errorf("unexpected channels %+v", channels)
+	}
+
+	var conns []ConnectionInfo
+	adminRequest(t, rts, "GET", "/connections", &conns)
+
+	if len(conns) != 2 {
+		t.Fatalf("expected 2 connections, got %+v", conns)
+	}
+
+	var room2 ConnectionInfo
+
+	for _, conn := range conns {
+		if len(conn.Channels) == 1 && conn.Channels[0] == "room.2" {
+			room2 = conn
+		}
+	}
+
+	if room2.Codec != "json" {
+		t.Errorf("expected a json connection subscribed to room.2, got %+v", conns)
+	}
+
+	if code := adminRequest(t, rts, "DELETE", "/channels/room.1", nil); code != http.StatusNoContent {
+		t.Errorf("expected %d closing channel, got %d", http.StatusNoContent, code)
+	}
+
+	var serverErr ServerError
+
+	if err := clients[0].ReadJSON(&serverErr); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := serverErr.Data.(map[string]interface{}); data["code"] != ErrCodeChannelClosed {
+		t.Errorf("expected channel closed error, got %+v", serverErr)
+	}
+
+	if _, ok := rts.Hub.findChannel("room.1"); ok {
+		t.Error("closed channel should leave the hub")
+	}
+
+	if code := adminRequest(t, rts, "DELETE", "/connections/"+room2.Id.String(), nil); code != http.StatusNoContent {
+		t.Errorf("expected %d disconnecting, got %d", http.StatusNoContent, code)
+	}
+
+	clients[1].SetReadDeadline(time.Now().Add(time.Second))
+	expectClose(t, clients[1], websocket.ClosePolicyViolation)
+
+	for _, path := range []string{"/channels/room.1", "/connections/nope"} {
+		if code := adminRequest(t, rts, "DELETE", path, nil); code != http.StatusNotFound {
+			t.Errorf("DELETE %s: expected %d, got %d", path, http.StatusNotFound, code)
+		}
+	}
+
+	if code := adminRequest(t, rts, "POST", "/channels", nil); code != http.StatusNotFound {
+		t.Errorf("expected %d for unknown route, got %d", http.StatusNotFound, code)
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Authenticator resolves the identity of a request before it is upgraded to a
+// websocket. Returning an error rejects the request.
+type Authenticator interface {
+	Authenticate(*http.Request) (interface{}, error)
+}
+
+type AuthenticatorFunc func(*http.Request) (interface{}, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (interface{}, error) {
+	return f(r)
+}
+
+type AuthError struct {
+	Status int
+	Msg    string
+}
+
+func (ae *AuthError) Error() string {
+	return ae.Msg
+}
+
+var (
+	ErrUnauthorized = &AuthError{Status: http.StatusUnauthorized, Msg: "unauthorized"}
+	ErrForbidden    = &AuthError{Status: http.StatusForbidden, Msg: "forbidden"}
+)
+
+// Writes the rejection for a failed authentication. Errors that are not an
+// *AuthError are treated as 401 Unauthorized.
+func writeAuthError(w http.ResponseWriter, err error) {
+	var authErr *AuthError
+
+	if !errors.As(err, &authErr) {
+		authErr = &AuthError{Status: http.StatusUnauthorized, Msg: err.Error()}
+	}
+
+	http.Error(w, authErr.Msg, authErr.Status)
+}
+
+type identityKey = string
+
+var IdentityKey = identityKey("identity")
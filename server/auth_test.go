@@ -0,0 +1,35 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeHTTPRejectsUnauthenticated(t *testing.T) {
+	rts := NewRealtimeServer(WithAuthenticator(AuthenticatorFunc(func(r *http.Request) (interface{}, error) {
+		switch r.URL.Query().Get("token") {
+		case "":
+			return nil, errors.New("missing token")
+		case "banned":
+			return nil, ErrForbidden
+		}
+
+		return r.URL.Query().Get("token"), nil
+	})))
+
+	rec := httptest.NewRecorder()
+	rts.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rt", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("missing token should respond %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	rts.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rt?token=banned", nil))
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("banned token should respond %d, got %d", http.StatusForbidden, rec.Code)
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// BrokerMessage is a channel event as it travels between nodes. Exclude holds
+// the id of the connection Broadcast was called for, so the sender is skipped
+// on whichever node it is connected to.
+type BrokerMessage struct {
+	Channel string          `json:"channel"`
+	Event   string          `json:"event"`
+	Data    json.RawMessage `json:"data"`
+	Exclude string          `json:"exclude,omitempty"`
+
+	// Seq is the message's sequence number within its channel, when the
+	// broker assigns one
+	Seq uint64 `json:"seq,omitempty"`
+
+	// value is the published data, which Data is the JSON encoding of. It is
+	// only set on messages delivered within the process that published them.
+	value interface{}
+	local bool
+}
+
+func newBrokerMessage(channel string, event string, data interface{}) (*BrokerMessage, error) {
+	raw, err := json.Marshal(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &BrokerMessage{
+		Channel: channel,
+		Event:   event,
+		Data:    raw,
+		value:   data,
+		local:   true,
+	}, nil
+}
+
+// Returns the data to send to connections. Messages delivered in-process,
+// such as by a MemoryBroker, keep the published value so codecs other than
+// JSON encode it directly instead of re-encoding its JSON.
+func (m *BrokerMessage) payload() interface{} {
+	if !m.local {
+		return m.Data
+	}
+
+	return publishedData{value: m.value, raw: m.Data}
+}
+
+type BrokerHandler func(*BrokerMessage)
+
+// Broker carries channel events between every node serving the same channels.
+// Channel.Emit and Channel.Broadcast publish through it and the hub subscribes
+// to each channel it has open, and to every channel while it has pattern
+// subscriptions.
+//
+// Brokers spanning nodes should set each message's Seq, numbering a
+// channel's messages in the order they are delivered, so every node stamps
+// the same sequence numbers and a client can resume with since on any node.
+// Messages without a Seq are numbered by each node, which only agrees on a
+// single node.
+type Broker interface {
+	Publish(*BrokerMessage) error
+	Subscribe(channel string, handler BrokerHandler) (unsubscribe func(), err error)
+	SubscribeAll(handler BrokerHandler) (unsubscribe func(), err error)
+	Close() error
+}
+
+// MemoryBroker delivers messages synchronously within a single process. It is
+// the default broker for a RealtimeServer.
+type MemoryBroker struct {
+	mu     sync.RWMutex
+	nextId int
+	subs   map[string]map[int]BrokerHandler
+	all    map[int]BrokerHandler
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		subs: make(map[string]map[int]BrokerHandler),
+		all:  make(map[int]BrokerHandler),
+	}
+}
+
+func (b *MemoryBroker) Publish(msg *BrokerMessage) error {
+	b.mu.RLock()
+	handlers := make([]BrokerHandler, 0, len(b.subs[msg.Channel])+len(b.all))
+
+	for _, handler := range b.subs[msg.Channel] {
+		handlers = append(handlers, handler)
+	}
+
+	for _, handler := range b.all {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(channel string, handler BrokerHandler) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextId++
+	id := b.nextId
+
+	if _, ok := b.subs[channel]; !ok {
+		b.subs[channel] = make(map[int]BrokerHandler)
+	}
+
+	b.subs[channel][id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subs[channel], id)
+
+		if len(b.subs[channel]) == 0 {
+			delete(b.subs, channel)
+		}
+	}, nil
+}
+
+func (b *MemoryBroker) SubscribeAll(handler BrokerHandler) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextId++
+	id := b.nextId
+	b.all[id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.all, id)
+	}, nil
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
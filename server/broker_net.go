@@ -0,0 +1,341 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+)
+
+const (
+	brokerSubscribe      = "sub"
+	brokerUnsubscribe    = "unsub"
+	brokerSubscribeAll   = "suball"
+	brokerUnsubscribeAll = "unsuball"
+	brokerPublish        = "pub"
+)
+
+// brokerFrame is the newline delimited JSON frame spoken between a NetBroker
+// and a BrokerServer.
+type brokerFrame struct {
+	Op      string         `json:"op"`
+	Channel string         `json:"channel,omitempty"`
+	Msg     *BrokerMessage `json:"msg,omitempty"`
+}
+
+var ErrBrokerClosed = errors.New("broker: closed")
+
+// BrokerServer is a minimal fan-out server over TCP or a Unix socket. Every
+// published message is numbered within its channel and forwarded to each peer
+// subscribed to its channel, including the publisher.
+type BrokerServer struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	peers map[*brokerPeer]bool
+
+	// seqMu is held while a message is numbered and forwarded so peers
+	// receive each channel's messages in sequence
+	seqMu sync.Mutex
+	seqs  map[string]uint64
+}
+
+type brokerPeer struct {
+	conn net.Conn
+
+	mu       sync.Mutex
+	enc      *json.Encoder
+	channels map[string]bool
+	all      bool
+}
+
+func ListenBroker(network string, addr string) (*BrokerServer, error) {
+	listener, err := net.Listen(network, addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &BrokerServer{
+		listener: listener,
+		peers:    make(map[*brokerPeer]bool),
+		seqs:     make(map[string]uint64),
+	}, nil
+}
+
+func (s *BrokerServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve accepts peers until the server is closed.
+func (s *BrokerServer) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+
+		if err != nil {
+			return err
+		}
+
+		peer := &brokerPeer{
+			conn:     conn,
+			enc:      json.NewEncoder(conn),
+			channels: make(map[string]bool),
+		}
+
+		s.mu.Lock()
+		s.peers[peer] = true
+		s.mu.Unlock()
+
+		go s.servePeer(peer)
+	}
+}
+
+func (s *BrokerServer) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for peer := range s.peers {
+		peer.conn.Close()
+	}
+
+	return err
+}
+
+func (s *BrokerServer) servePeer(peer *brokerPeer) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.peers, peer)
+		s.mu.Unlock()
+
+		peer.conn.Close()
+	}()
+
+	dec := json.NewDecoder(peer.conn)
+
+	for {
+		var frame brokerFrame
+
+		if err := dec.Decode(&frame); err != nil {
+			return
+		}
+
+		switch frame.Op {
+		case brokerSubscribe:
+			peer.mu.Lock()
+			peer.channels[frame.Channel] = true
+			peer.mu.Unlock()
+		case brokerUnsubscribe:
+			peer.mu.Lock()
+			delete(peer.channels, frame.Channel)
+			peer.mu.Unlock()
+		case brokerSubscribeAll, brokerUnsubscribeAll:
+			peer.mu.Lock()
+			peer.all = frame.Op == brokerSubscribeAll
+			peer.mu.Unlock()
+		case brokerPublish:
+			if frame.Msg != nil {
+				s.fanout(&frame)
+			}
+		}
+	}
+}
+
+func (s *BrokerServer) fanout(frame *brokerFrame) {
+	s.seqMu.Lock()
+	defer s.seqMu.Unlock()
+
+	s.seqs[frame.Msg.Channel]++
+	frame.Msg.Seq = s.seqs[frame.Msg.Channel]
+
+	s.mu.Lock()
+	peers := make([]*brokerPeer, 0, len(s.peers))
+
+	for peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	s.mu.Unlock()
+
+	for _, peer := range peers {
+		peer.mu.Lock()
+
+		if peer.all || peer.channels[frame.Msg.Channel] {
+			if err := peer.enc.Encode(frame); err != nil {
+				peer.conn.Close()
+			}
+		}
+
+		peer.mu.Unlock()
+	}
+}
+
+// NetBroker is a Broker connected to a BrokerServer. Messages published by
+// any node reach the subscribers of every node connected to the same server.
+type NetBroker struct {
+	conn net.Conn
+
+	wmu sync.Mutex
+	enc *json.Encoder
+
+	mu     sync.RWMutex
+	nextId int
+	subs   map[string]map[int]BrokerHandler
+	all    map[int]BrokerHandler
+
+	logMu  sync.Mutex
+	logger Logger
+}
+
+func DialBroker(network string, addr string) (*NetBroker, error) {
+	conn, err := net.Dial(network, addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b := &NetBroker{
+		conn:   conn,
+		enc:    json.NewEncoder(conn),
+		subs:   make(map[string]map[int]BrokerHandler),
+		all:    make(map[int]BrokerHandler),
+		logger: defaultLogger,
+	}
+
+	go b.read()
+
+	return b, nil
+}
+
+// SetLogger sets the logger the broker reports connection and subscription
+// errors to. Defaults to the same logger as a RealtimeServer.
+func (b *NetBroker) SetLogger(logger Logger) {
+	b.logMu.Lock()
+	defer b.logMu.Unlock()
+
+	b.logger = logger
+}
+
+func (b *NetBroker) log(level Level, msg string, fields ...Field) {
+	b.logMu.Lock()
+	logger := b.logger
+	b.logMu.Unlock()
+
+	logger.Log(level, msg, fields...)
+}
+
+func (b *NetBroker) read() {
+	dec := json.NewDecoder(b.conn)
+
+	for {
+		var frame brokerFrame
+
+		if err := dec.Decode(&frame); err != nil {
+			b.log(LevelWarn, "broker connection closed", Field{Key: "addr", Value: b.conn.RemoteAddr()}, errField(err))
+			return
+		}
+
+		if frame.Op != brokerPublish || frame.Msg == nil {
+			continue
+		}
+
+		b.mu.RLock()
+		handlers := make([]BrokerHandler, 0, len(b.subs[frame.Msg.Channel])+len(b.all))
+
+		for _, handler := range b.subs[frame.Msg.Channel] {
+			handlers = append(handlers, handler)
+		}
+
+		for _, handler := range b.all {
+			handlers = append(handlers, handler)
+		}
+		b.mu.RUnlock()
+
+		for _, handler := range handlers {
+			handler(frame.Msg)
+		}
+	}
+}
+
+func (b *NetBroker) send(frame *brokerFrame) error {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+
+	if err := b.enc.Encode(frame); err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return ErrBrokerClosed
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (b *NetBroker) Publish(msg *BrokerMessage) error {
+	return b.send(&brokerFrame{Op: brokerPublish, Msg: msg})
+}
+
+func (b *NetBroker) Subscribe(channel string, handler BrokerHandler) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[channel]; !ok {
+		if err := b.send(&brokerFrame{Op: brokerSubscribe, Channel: channel}); err != nil {
+			return nil, err
+		}
+
+		b.subs[channel] = make(map[int]BrokerHandler)
+	}
+
+	b.nextId++
+	id := b.nextId
+	b.subs[channel][id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subs[channel], id)
+
+		if len(b.subs[channel]) == 0 {
+			delete(b.subs, channel)
+
+			if err := b.send(&brokerFrame{Op: brokerUnsubscribe, Channel: channel}); err != nil {
+				b.log(LevelError, "broker unsubscribe failed", channelField(channel), errField(err))
+			}
+		}
+	}, nil
+}
+
+func (b *NetBroker) SubscribeAll(handler BrokerHandler) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.all) == 0 {
+		if err := b.send(&brokerFrame{Op: brokerSubscribeAll}); err != nil {
+			return nil, err
+		}
+	}
+
+	b.nextId++
+	id := b.nextId
+	b.all[id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.all, id)
+
+		if len(b.all) == 0 {
+			if err := b.send(&brokerFrame{Op: brokerUnsubscribeAll}); err != nil {
+				b.log(LevelError, "broker unsubscribe from all channels failed", errField(err))
+			}
+		}
+	}, nil
+}
+
+func (b *NetBroker) Close() error {
+	return b.conn.Close()
+}
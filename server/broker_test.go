@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBroadcastExcludesSenderAcrossNodes(t *testing.T) {
+	bs, err := ListenBroker("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer bs.Close()
+	go bs.Serve()
+
+	hubs := make([]*Hub, 2)
+
+	for i := range hubs {
+		broker, err := DialBroker("tcp", bs.Addr().String())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer broker.Close()
+
+		hubs[i] = newHub()
+		hubs[i].broker = broker
+		hubs[i].registerChannelFactory(NewChannelFactory("room.{id}"))
+	}
+
+	var conns []*Connection
+
+	for _, hub := range hubs {
+		channel, _ := hub.findOrOpenChannel("room.1")
+		conn := newTestConnection()
+		msg := &ClientMessage{Message: Message{Type: Subscribe, Channel: "room.1"}}
+		channel.handleEvent(context.Background(), NewEvent(channel, conn, msg))
+		conns = append(conns, conn)
+	}
+
+	sender := conns[0]
+	channel, _ := hubs[0].findChannel("room.1")
+
+	// Subscriptions reach the broker server asynchronously, so publish until
+	// the remote node receives the message.
+	deadline := time.After(2 * time.Second)
+
+	for {
+		channel.Broadcast("hello", "world", sender)
+
+		select {
+		case <-conns[1].send.ready:
+			bytes := nextMessage(t, conns[1])
+			var msg ServerMessage
+			json.Unmarshal(bytes, &msg)
+
+			if msg.Event != "hello" || msg.Data != "world" {
+				t.Errorf("unexpected message %s", bytes)
+			}
+
+			if sender.send.len() != 0 {
+				t.Error("sender should be excluded from broadcast")
+			}
+
+			return
+		case <-time.After(20 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("remote node never received broadcast")
+		}
+	}
+}
+
+func TestBrokerServerNumbersMessagesAcrossNodes(t *testing.T) {
+	bs, err := ListenBroker("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer bs.Close()
+	go bs.Serve()
+
+	hubs := make([]*Hub, 2)
+
+	for i := range hubs {
+		broker, err := DialBroker("tcp", bs.Addr().String())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer broker.Close()
+
+		hubs[i] = newHub()
+		hubs[i].broker = broker
+		hubs[i].registerChannelFactory(NewChannelFactory("room.{id}"))
+	}
+
+	subscribe := func(hub *Hub) (*Channel, *Connection) {
+		channel, _ := hub.findOrOpenChannel("room.1")
+		conn := newTestConnection()
+		channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+			Message: Message{Type: Subscribe, Channel: "room.1"},
+		}))
+
+		return channel, conn
+	}
+
+	// Publishes from channel until conn receives a message, returning its seq
+	receive := func(channel *Channel, conn *Connection) uint64 {
+		deadline := time.After(2 * time.Second)
+
+		for {
+			channel.Emit("hello", nil)
+
+			select {
+			case <-conn.send.ready:
+				var msg ServerMessage
+				json.Unmarshal(nextMessage(t, conn), &msg)
+
+				return msg.Seq
+			case <-time.After(20 * time.Millisecond):
+			case <-deadline:
+				t.Fatal("message never received")
+			}
+		}
+	}
+
+	first, early := subscribe(hubs[0])
+	seen := receive(first, early)
+
+	second, late := subscribe(hubs[1])
+
+	// Numbered by each node, the late node would restart at seq 1
+	if seq := receive(second, late); seq <= seen {
+		t.Errorf("expected the late node to continue the broker's sequence after %d, got %d", seen, seq)
+	}
+}
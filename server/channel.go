@@ -3,11 +3,12 @@ package server
 import (
 	"context"
 	"fmt"
-	"log"
 	"sync"
+	"time"
 )
 
 const (
+	Authorize   = "__CHANNEL_AUTHORIZE__"
 	BeforeJoin  = "__CHANNEL_BEFORE_JOIN__"
 	Join        = "__CHANNEL_JOIN__"
 	BeforeLeave = "__CHANNEL_BEFORE_LEAVE__"
@@ -24,6 +25,8 @@ type Channel struct {
 	factory     *ChannelFactory
 	connections ConnectionMap
 	hub         *Hub
+	presence    *presence
+	history     *history
 }
 
 func (c *Channel) String() string {
@@ -31,76 +34,159 @@ func (c *Channel) String() string {
 }
 
 /**
-Sends message to all connected clients includeing sender
+Sends message to all connected clients includeing sender. data must not be
+modified once emitted
 */
 func (c *Channel) Emit(event string, data interface{}) {
-	serverMessage := c.newServerMessage(event, data)
-	c.sendMessage(serverMessage)
+	c.publish(event, data, nil)
 }
 
 /**
 Sends message to all clients excluding sender
 */
 func (c *Channel) Broadcast(event string, data interface{}, conn *Connection) {
-	serverMessage := c.newServerMessage(event, data)
-	c.broadcastMessage(serverMessage, conn)
+	c.publish(event, data, conn)
 }
 
-func newChannel(name string, params *Params, factory *ChannelFactory, hub *Hub) *Channel {
-	return &Channel{
+func (c *Channel) publish(event string, data interface{}, exclude *Connection) {
+	msg, err := newBrokerMessage(c.Name, event, data)
+
+	if err != nil {
+		c.log(LevelError, "marshalling message failed", eventField(event), errField(err))
+		return
+	}
+
+	if exclude != nil {
+		msg.Exclude = exclude.Id.String()
+	}
+
+	if err := c.hub.broker.Publish(msg); err != nil {
+		c.log(LevelError, "publishing message failed", eventField(event), errField(err))
+	}
+}
+
+// SubscriberCount returns the number of this node's connections subscribed to
+// the channel.
+func (c *Channel) SubscriberCount() int {
+	c.history.mu.Lock()
+	defer c.history.mu.Unlock()
+
+	return len(c.connections)
+}
+
+func (c *Channel) hasConnection(conn *Connection) bool {
+	c.history.mu.Lock()
+	defer c.history.mu.Unlock()
+
+	return c.connections[conn]
+}
+
+// Delivers a message received from the broker to this node's connections.
+func (c *Channel) deliver(msg *BrokerMessage) {
+	serverMessage := c.newServerMessage(msg.Event, msg.payload())
+	serverMessage.Seq = msg.Seq
+	c.broadcastMessage(serverMessage, msg.Exclude)
+}
+
+func newChannel(name string, params *Params, factory *ChannelFactory, hub *Hub, h *history) *Channel {
+	c := &Channel{
 		Name:        name,
 		Path:        factory.path,
 		Params:      params,
 		factory:     factory,
 		connections: make(ConnectionMap),
 		hub:         hub,
+		history:     h,
 	}
+
+	if factory.presence {
+		c.presence = newPresence()
+	}
+
+	return c
+}
+
+// Presence returns the current presence list, or nil when the channel's
+// factory does not track presence.
+func (c *Channel) Presence() []PresenceEntry {
+	if c.presence == nil {
+		return nil
+	}
+
+	return c.presence.list()
+}
+
+// Logs an entry about the channel through the hub's logger.
+func (c *Channel) log(level Level, msg string, fields ...Field) {
+	c.hub.logger.Log(level, msg, c.logFields(fields...)...)
 }
 
 func (c *Channel) handleEvent(ctx context.Context, event *Event) {
+	c.hub.metrics.messageReceived(c.Path, c.eventLabel(event))
+
 	switch event.Msg.Type {
 	case Subscribe:
 		c.handleRegister(ctx, event)
 	case Unsubscribe:
 		c.removeConnection(ctx, event)
 	case ClientEvent:
+		// Only members may send events or read presence, so Authorize guards both
+		if !c.hasConnection(event.Conn) {
+			c.log(LevelDebug, "event from non-member rejected", connField(event.Conn), eventField(event.Name()))
+			event.Error(NewServerError("Not subscribed to channel", ServerErrorFields{
+				"channel": c.Name,
+				"event":   event.Name(),
+				"code":    ErrCodeNotSubscribed,
+			}))
+
+			return
+		}
+
+		if !c.allowEvent(event) {
+			return
+		}
+
+		if c.presence != nil && event.Name() == PresenceState {
+			event.Send(PresenceState, c.presence.list())
+			return
+		}
+
 		c.handleClientEvent(ctx, event.Name(), event)
 	}
 }
 
-func (c *Channel) sendMessage(msg *ServerMessage) {
-	bytes, err := msg.Marshal()
-
-	if err != nil {
-		log.Printf("[%s] Error marshalling message %v", c, err)
-		return
+// Labels an event for metrics by its name, if the channel handles it, or
+// its type. Unhandled event names are chosen by clients, so they are not
+// used as labels.
+func (c *Channel) eventLabel(event *Event) string {
+	if event.Msg.Type != ClientEvent {
+		return string(event.Msg.Type)
 	}
 
-	for conn := range c.connections {
-		select {
-		case conn.byteSend <- bytes:
-		default:
-		}
+	if _, exists := c.factory.handler(event.Name()); exists || c.presence != nil && event.Name() == PresenceState {
+		return event.Name()
 	}
+
+	return "unhandled"
 }
 
-func (c *Channel) broadcastMessage(msg *ServerMessage, conn *Connection) {
-	bytes, err := msg.Marshal()
+// Stamps, records and fans out msg to every connection except the one with
+// the exclude id. The history lock is held throughout so subscribers
+// replaying history never observe live messages out of sequence.
+func (c *Channel) broadcastMessage(msg *ServerMessage, exclude string) {
+	c.history.mu.Lock()
+	defer c.history.mu.Unlock()
 
-	if err != nil {
-		log.Printf("[%s] Error marshalling message %v", c, err)
-		return
-	}
+	prepared := c.history.record(msg)
+	prepared.uncompressed = c.factory.uncompressed
 
 	for connection := range c.connections {
-		if connection == conn {
+		if exclude != "" && connection.Id.String() == exclude {
 			continue
 		}
 
-		log.Printf("[%s] Sending msg to %s", c, conn)
-		select {
-		case connection.byteSend <- bytes:
-		default:
+		if err := connection.sendPrepared(prepared); err != nil {
+			c.log(LevelError, "marshalling message failed", connField(connection), eventField(msg.Event), errField(err))
 		}
 	}
 }
@@ -109,19 +195,24 @@ func (c *Channel) handleClientEvent(ctx context.Context, eventName string, event
 	handler, exists := c.factory.handler(eventName)
 
 	if !exists {
-		log.Printf("[%s] Handler '%s' not available for channel %s", c, eventName, c.Name)
+		c.log(LevelDebug, "handler not available", connField(event.Conn), eventField(eventName))
 		err := NewServerError("Handler not available for channel", ServerErrorFields{
 			"channel": c.Name,
 			"event":   eventName,
 		})
-		event.Conn.handleError(err)
+		event.Error(err)
 
 		return nil
 	}
 
-	log.Printf("[%s] Handling event: %s", c, eventName)
+	err := c.runHandler(ctx, handler, event)
 
-	return handler.handler(ctx, event)
+	if err != nil {
+		c.log(LevelWarn, "handler failed", connField(event.Conn), eventField(eventName), errField(err))
+		event.Error(err)
+	}
+
+	return err
 }
 
 func (c *Channel) handleBuiltinEvent(ctx context.Context, eventName string, event *Event) error {
@@ -131,23 +222,176 @@ func (c *Channel) handleBuiltinEvent(ctx context.Context, eventName string, even
 		return nil
 	}
 
-	return handler.handler(ctx, event)
+	return c.runHandler(ctx, handler, event)
 }
 
-func (c *Channel) handleRegister(ctx context.Context, event *Event) error {
-	err := c.handleBuiltinEvent(ctx, BeforeJoin, event)
+// Runs the handler wrapped in the server's and then the factory's middleware.
+func (c *Channel) runHandler(ctx context.Context, entry channelEntry, event *Event) error {
+	event.Handler = entry.event
+	handler := c.hub.wrap(c.factory.wrap(entry.handler))
+
+	ctx, span := c.hub.tracer.Start(ctx, "realtime.handler")
+	defer span.End()
+
+	span.SetAttribute("channel", c.Name)
+	span.SetAttribute("path", c.Path)
+	span.SetAttribute("handler", hookName(entry.event))
+
+	start := time.Now()
+	err := handler(ctx, event)
+	c.hub.metrics.handled(c.Path, entry.event, time.Since(start), err)
 
 	if err != nil {
+		span.RecordError(err)
+	}
+
+	return err
+}
+
+var hookNames = map[string]string{
+	Authorize:   "Authorize",
+	BeforeJoin:  "BeforeJoin",
+	Join:        "Join",
+	BeforeLeave: "BeforeLeave",
+	Leave:       "Leave",
+}
+
+// Names the handler for an event, using readable names for the built-in
+// hooks.
+func hookName(event string) string {
+	if name, ok := hookNames[event]; ok {
+		return name
+	}
+
+	return event
+}
+
+func (c *Channel) handleRegister(ctx context.Context, event *Event) error {
+	if err := c.authorize(ctx, event); err != nil {
+		c.log(LevelInfo, "join rejected", connField(event.Conn), errField(err))
+		event.Error(c.rejectionError(err))
+		c.closeIfEmpty()
+
 		return err
 	}
 
+	return c.join(ctx, event)
+}
+
+// Adds the connection and runs the Join hook. A Subscribe may find the
+// channel just before its last connection leaves and closes it, in which case
+// the connection joins the reopened channel instead.
+func (c *Channel) join(ctx context.Context, event *Event) error {
 	c.mu.Lock()
+
+	if !c.hub.isOpen(c) {
+		c.mu.Unlock()
+
+		channel, ok := c.hub.findOrOpenChannel(c.Name)
+
+		if !ok {
+			event.Error(NewServerError("Channel not found", ServerErrorFields{
+				"channel": c.Name,
+			}))
+
+			return ErrChannelNotFound
+		}
+
+		event.Channel = channel
+
+		return channel.join(ctx, event)
+	}
+
 	defer c.mu.Unlock()
 
+	if !c.addConnection(event) {
+		c.log(LevelDebug, "closed connection not joined", connField(event.Conn))
+
+		if len(c.connections) == 0 {
+			c.closeChannel()
+		}
+
+		return errConnectionClosed
+	}
+
+	if c.presence != nil {
+		c.trackPresence(event)
+	}
+
+	if err := c.handleBuiltinEvent(ctx, Join, event); err != nil {
+		c.log(LevelWarn, "join handler failed", connField(event.Conn), errField(err))
+		c.dropConnection(event)
+		event.Error(err)
+
+		if len(c.connections) == 0 {
+			c.closeChannel()
+		}
+
+		return err
+	}
+
+	// Confirms the subscription to clients that asked for a reply
+	if event.Ref() != "" {
+		event.Reply(nil)
+	}
+
+	return nil
+}
+
+// Adds the connection, first replaying any retained messages it missed when
+// Subscribe carries a since sequence. Returns false without adding it when
+// the connection has closed. Callers must hold c.mu.
+func (c *Channel) addConnection(event *Event) bool {
+	c.history.mu.Lock()
+	defer c.history.mu.Unlock()
+
+	if !event.Conn.addChannel(c) {
+		return false
+	}
+
+	if event.Msg.Since != nil {
+		for _, prepared := range c.history.since(*event.Msg.Since) {
+			event.Conn.sendPrepared(prepared)
+		}
+	}
+
 	c.connections[event.Conn] = true
-	event.Conn.addChannel(c)
+	c.hub.metrics.subscribed(c.Path)
+	c.log(LevelDebug, "connection joined", connField(event.Conn))
+
+	return true
+}
+
+// Runs the Authorize and BeforeJoin hooks. Any error returned by either
+// prevents the connection from being added to the channel.
+func (c *Channel) authorize(ctx context.Context, event *Event) error {
+	if err := c.handleBuiltinEvent(ctx, Authorize, event); err != nil {
+		return err
+	}
+
+	return c.handleBuiltinEvent(ctx, BeforeJoin, event)
+}
+
+func (c *Channel) rejectionError(err error) *ServerError {
+	rejection, ok := err.(*Rejection)
+
+	if !ok {
+		rejection = Reject(RejectJoinFailed, err.Error())
+	}
+
+	return NewServerError(rejection.Msg, ServerErrorFields{
+		"channel": c.Name,
+		"code":    rejection.Code,
+	})
+}
+
+func (c *Channel) closeIfEmpty() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return c.handleBuiltinEvent(ctx, Join, event)
+	if len(c.connections) == 0 {
+		c.closeChannel()
+	}
 }
 
 func (c *Channel) removeConnection(ctx context.Context, event *Event) {
@@ -160,25 +404,78 @@ func (c *Channel) removeConnection(ctx context.Context, event *Event) {
 		return
 	}
 
-	log.Printf("[%s] Removing connection %s", c, event.Conn)
-	delete(c.connections, event.Conn)
+	c.log(LevelDebug, "connection left", connField(event.Conn))
+	c.dropConnection(event)
 
 	err := c.handleBuiltinEvent(ctx, Leave, event)
 
 	if err != nil {
-		log.Println(err)
+		c.log(LevelWarn, "leave handler failed", connField(event.Conn), errField(err))
 	}
 
-	event.Conn.removeChannel(c)
-
 	if len(c.connections) == 0 {
 		c.closeChannel()
 	}
 }
 
-func (c *Channel) closeChannel() {
-	log.Printf("[%s] Closing channel", c)
+// Removes the connection and its presence without running the Leave hook, so
+// a connection whose Join hook failed can be dropped. Callers must hold c.mu.
+func (c *Channel) dropConnection(event *Event) {
+	c.history.mu.Lock()
+	delete(c.connections, event.Conn)
+	c.history.mu.Unlock()
+
+	c.hub.metrics.unsubscribed(c.Path)
+
+	if c.presence != nil {
+		c.untrackPresence(event)
+	}
+
+	event.Conn.removeChannel(c)
+}
+
+func (c *Channel) trackPresence(event *Event) {
+	entry, joined := c.presence.track(event.Conn, c.presenceMeta(event))
+
+	event.Send(PresenceState, c.presence.list())
+
+	if joined {
+		c.Broadcast(PresenceDiff, PresenceDiffData{
+			Joins:  []PresenceEntry{entry},
+			Leaves: []PresenceEntry{},
+		}, event.Conn)
+	}
+}
 
+// Decodes the metadata sent with Subscribe with the connection's codec, so
+// it can be sent to connections using any codec.
+func (c *Channel) presenceMeta(event *Event) interface{} {
+	if len(event.Msg.RawData) == 0 {
+		return nil
+	}
+
+	var meta interface{}
+
+	if err := event.Data(&meta); err != nil {
+		c.log(LevelWarn, "invalid presence metadata", connField(event.Conn), errField(err))
+		return nil
+	}
+
+	return meta
+}
+
+func (c *Channel) untrackPresence(event *Event) {
+	entry, left := c.presence.untrack(event.Conn)
+
+	if left {
+		c.Emit(PresenceDiff, PresenceDiffData{
+			Joins:  []PresenceEntry{},
+			Leaves: []PresenceEntry{entry},
+		})
+	}
+}
+
+func (c *Channel) closeChannel() {
 	c.hub.closeChannel(c)
 }
 
@@ -192,3 +489,11 @@ func (c *Channel) newServerMessage(event string, data interface{}) *ServerMessag
 		Data:  data,
 	}
 }
+
+// Prepares msg for sending, honoring the factory's compression opt-out.
+func (c *Channel) prepare(msg *ServerMessage) *preparedMessage {
+	prepared := prepare(msg)
+	prepared.uncompressed = c.factory.uncompressed
+
+	return prepared
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func newTestConnection() *Connection {
+	return &Connection{
+		Id:       uuid.New(),
+		codec:    JSONCodec,
+		send:     newSendQueue(SendQueueConfig{Size: 16}),
+		channels: make(map[*Channel]bool),
+	}
+}
+
+func nextMessage(t *testing.T, conn *Connection) []byte {
+	t.Helper()
+
+	msg, ok := conn.send.pop()
+
+	if !ok {
+		t.Fatal("expected a queued message")
+	}
+
+	return msg.data
+}
+
+func TestChannelAuthorizeRejects(t *testing.T) {
+	hub := newHub()
+	cf := NewChannelFactory("private.{id}")
+	cf.Authorize(func(ctx context.Context, e *Event) error {
+		return Reject(RejectForbidden, "not a member")
+	})
+	hub.registerChannelFactory(cf)
+
+	channel, ok := hub.findOrOpenChannel("private.1")
+
+	if !ok {
+		t.Fatal("private.1 should open a channel")
+	}
+
+	conn := newTestConnection()
+	msg := &ClientMessage{Message: Message{Type: Subscribe, Channel: "private.1"}}
+	channel.handleEvent(context.Background(), NewEvent(channel, conn, msg))
+
+	if _, joined := channel.connections[conn]; joined {
+		t.Error("rejected connection should not be added to channel")
+	}
+
+	var serverErr ServerError
+	json.Unmarshal(nextMessage(t, conn), &serverErr)
+
+	fields := serverErr.Data.(map[string]interface{})
+
+	if fields["code"] != RejectForbidden || fields["channel"] != "private.1" {
+		t.Errorf("unexpected rejection fields %v", fields)
+	}
+
+	if _, ok := hub.findChannel("private.1"); ok {
+		t.Error("empty channel should be closed after rejection")
+	}
+}
+
+func TestHandleReplyEchoesRef(t *testing.T) {
+	hub := newHub()
+	cf := NewChannelFactory("room")
+	cf.HandleReply("sum", func(ctx context.Context, e *Event) (interface{}, error) {
+		var nums []int
+
+		if err := e.Data(&nums); err != nil {
+			return nil, err
+		}
+
+		return nums[0] + nums[1], nil
+	})
+	hub.registerChannelFactory(cf)
+
+	channel, _ := hub.findOrOpenChannel("room")
+	conn := newTestConnection()
+
+	channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+		Message: Message{Type: Subscribe, Channel: "room"},
+	}))
+
+	msg := &ClientMessage{
+		Message: Message{Type: ClientEvent, Channel: "room"},
+		Event:   "sum",
+		RawData: json.RawMessage(`[1, 2]`),
+		Ref:     "1",
+	}
+	channel.handleEvent(context.Background(), NewEvent(channel, conn, msg))
+
+	var reply ServerMessage
+	json.Unmarshal(nextMessage(t, conn), &reply)
+
+	if reply.Type != ServerReply || reply.Ref != "1" || reply.Data != float64(3) {
+		t.Errorf("unexpected reply %#v", reply)
+	}
+
+	msg = &ClientMessage{
+		Message: Message{Type: ClientEvent, Channel: "room"},
+		Event:   "sum",
+		RawData: json.RawMessage(`"nope"`),
+		Ref:     "2",
+	}
+	channel.handleEvent(context.Background(), NewEvent(channel, conn, msg))
+
+	var serverErr ServerError
+	json.Unmarshal(nextMessage(t, conn), &serverErr)
+
+	if serverErr.Type != ServerErrorMessageType || serverErr.Ref != "2" {
+		t.Errorf("unexpected error reply %#v", serverErr)
+	}
+}
+
+func TestClientEventRequiresMembership(t *testing.T) {
+	hub := newHub()
+	cf := NewChannelFactory("private.{id}")
+	cf.Authorize(func(ctx context.Context, e *Event) error {
+		if e.Identity() != "member" {
+			return Reject(RejectForbidden, "not a member")
+		}
+
+		return nil
+	})
+
+	var said []string
+
+	cf.Handle("say", func(ctx context.Context, e *Event) error {
+		said = append(said, e.Identity().(string))
+		return nil
+	})
+	hub.registerChannelFactory(cf)
+
+	channel, _ := hub.findOrOpenChannel("private.1")
+	member := newTestConnection()
+	member.Identity = "member"
+	intruder := newTestConnection()
+	intruder.Identity = "intruder"
+
+	for _, conn := range []*Connection{member, intruder} {
+		channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+			Message: Message{Type: Subscribe, Channel: "private.1"},
+		}))
+	}
+
+	// Drops the intruder's rejection
+	nextMessage(t, intruder)
+
+	channel.handleEvent(context.Background(), NewEvent(channel, intruder, &ClientMessage{
+		Message: Message{Type: ClientEvent, Channel: "private.1"},
+		Event:   "say",
+		Ref:     "1",
+	}))
+
+	if len(said) != 0 {
+		t.Errorf("handler should not run for non-members, ran for %v", said)
+	}
+
+	var serverErr ServerError
+	json.Unmarshal(nextMessage(t, intruder), &serverErr)
+
+	if fields, _ := serverErr.Data.(map[string]interface{}); fields["code"] != ErrCodeNotSubscribed || serverErr.Ref != "1" {
+		t.Errorf("expected not subscribed error with ref, got %#v", serverErr)
+	}
+
+	channel.handleEvent(context.Background(), NewEvent(channel, member, &ClientMessage{
+		Message: Message{Type: ClientEvent, Channel: "private.1"},
+		Event:   "say",
+	}))
+
+	if len(said) != 1 || said[0] != "member" {
+		t.Errorf("handler should run for members, ran for %v", said)
+	}
+}
+
+func TestJoinErrorAnswersSubscribe(t *testing.T) {
+	hub := newHub()
+	cf := NewChannelFactory("room")
+	cf.Join(func(ctx context.Context, e *Event) error {
+		return errors.New("join failed")
+	})
+	hub.registerChannelFactory(cf)
+
+	channel, _ := hub.findOrOpenChannel("room")
+	conn := newTestConnection()
+
+	channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+		Message: Message{Type: Subscribe, Channel: "room"},
+		Ref:     "1",
+	}))
+
+	var serverErr ServerError
+	json.Unmarshal(nextMessage(t, conn), &serverErr)
+
+	if serverErr.Type != ServerErrorMessageType || serverErr.Msg != "join failed" || serverErr.Ref != "1" {
+		t.Errorf("expected the Join error with ref, got %#v", serverErr)
+	}
+
+	if channel.hasConnection(conn) || len(conn.channels) != 0 {
+		t.Error("a connection whose Join failed should not stay subscribed")
+	}
+
+	if _, ok := hub.findChannel("room"); ok {
+		t.Error("the channel should close once its only join fails")
+	}
+}
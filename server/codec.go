@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes the messages sent over a connection. It is negotiated with
+// the client through the websocket subprotocol matching its Name.
+type Codec interface {
+	Name() string
+	// FrameType is the websocket message type frames are sent with
+	FrameType() int
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) FrameType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// msgpackCodec sends MessagePack in binary frames. Structs are encoded with
+// their json tags so both codecs share field names.
+type msgpackCodec struct{}
+
+// msgpackClientMessage mirrors ClientMessage, keeping data raw until a
+// handler decodes it.
+type msgpackClientMessage struct {
+	Type     ConnectionEvent    `json:"type"`
+	Channel  string             `json:"channel"`
+	Event    string             `json:"event"`
+	Data     msgpack.RawMessage `json:"data"`
+	Ref      string             `json:"ref"`
+	Since    *uint64            `json:"since"`
+	Metadata map[string]string  `json:"meta"`
+}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	// Payloads that arrive from another node's broker are JSON and are
+	// re-encoded
+	if sm, ok := v.(*ServerMessage); ok {
+		if raw, ok := sm.Data.(json.RawMessage); ok {
+			data, err := decodeJSONValue(raw)
+
+			if err != nil {
+				return nil, err
+			}
+
+			copied := *sm
+			copied.Data = data
+			v = &copied
+		}
+	}
+
+	var buf bytes.Buffer
+
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+
+	cm, ok := v.(*ClientMessage)
+
+	if !ok {
+		return dec.Decode(v)
+	}
+
+	var wire msgpackClientMessage
+
+	if err := dec.Decode(&wire); err != nil {
+		return err
+	}
+
+	*cm = ClientMessage{
+		Message:  Message{Type: wire.Type, Channel: wire.Channel},
+		Event:    wire.Event,
+		RawData:  json.RawMessage(wire.Data),
+		Ref:      wire.Ref,
+		Since:    wire.Since,
+		Metadata: wire.Metadata,
+		codec:    MsgpackCodec,
+	}
+
+	return nil
+}
+
+// publishedData is a published value alongside its JSON encoding. JSON
+// connections reuse the encoding and MessagePack connections encode the value
+// itself, so binary data stays binary.
+type publishedData struct {
+	value interface{}
+	raw   json.RawMessage
+}
+
+func (d publishedData) MarshalJSON() ([]byte, error) {
+	return d.raw, nil
+}
+
+func (d publishedData) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return enc.Encode(d.value)
+}
+
+// Decodes raw JSON keeping integers as integers rather than float64.
+func decodeJSONValue(raw json.RawMessage) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var data interface{}
+
+	if err := dec.Decode(&data); err != nil {
+		return nil, err
+	}
+
+	return convertNumbers(data), nil
+}
+
+func convertNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = convertNumbers(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = convertNumbers(value)
+		}
+	}
+
+	return v
+}
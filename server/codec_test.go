@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+type echoData struct {
+	Count int `json:"count"`
+}
+
+func readMsgpack(t *testing.T, ws *websocket.Conn, v interface{}) {
+	t.Helper()
+
+	frameType, data, err := ws.ReadMessage()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if frameType != websocket.BinaryMessage {
+		t.Fatalf("expected binary frame, got %d", frameType)
+	}
+
+	if err := MsgpackCodec.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeMsgpack(t *testing.T, ws *websocket.Conn, v interface{}) {
+	t.Helper()
+
+	data, err := MsgpackCodec.Marshal(v)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMsgpackNegotiation(t *testing.T) {
+	rts := NewRealtimeServer()
+	cf := NewChannelFactory("room")
+
+	cf.HandleReply("echo", func(ctx context.Context, e *Event) (interface{}, error) {
+		var data echoData
+
+		if err := e.Data(&data); err != nil {
+			return nil, err
+		}
+
+		data.Count++
+
+		return data, nil
+	})
+	rts.RegisterChannelFactory(cf)
+
+	srv := httptest.NewServer(rts)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	dialer := websocket.Dialer{Subprotocols: []string{"msgpack"}}
+	ws, _, err := dialer.Dial(url, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ws.Close()
+
+	if ws.Subprotocol() != "msgpack" {
+		t.Fatalf("expected msgpack subprotocol, got %q", ws.Subprotocol())
+	}
+
+	writeMsgpack(t, ws, &ClientMessage{Message: Message{Type: Subscribe, Channel: "room"}, Ref: "1"})
+
+	var reply ServerMessage
+	readMsgpack(t, ws, &reply)
+
+	if reply.Type != ServerReply || reply.Ref != "1" {
+		t.Fatalf("expected subscribe reply, got %+v", reply)
+	}
+
+	writeMsgpack(t, ws, map[string]interface{}{
+		"type":    ClientEvent,
+		"channel": "room",
+		"event":   "echo",
+		"data":    echoData{Count: 1},
+		"ref":     "2",
+	})
+
+	var echo struct {
+		Ref  string   `json:"ref"`
+		Data echoData `json:"data"`
+	}
+	readMsgpack(t, ws, &echo)
+
+	if echo.Ref != "2" || echo.Data.Count != 2 {
+		t.Errorf("expected echoed count 2 for ref 2, got %+v", echo)
+	}
+}
+
+func TestDefaultCodecIsJSON(t *testing.T) {
+	rts := NewRealtimeServer()
+	rts.RegisterChannelFactory(NewChannelFactory("room"))
+
+	srv := httptest.NewServer(rts)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ws.Close()
+
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: Subscribe, Channel: "room"}, Ref: "1"})
+
+	frameType, data, err := ws.ReadMessage()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reply ServerMessage
+
+	if frameType != websocket.TextMessage || json.Unmarshal(data, &reply) != nil || reply.Ref != "1" {
+		t.Errorf("expected JSON text reply, got %d %s", frameType, data)
+	}
+}
+
+func TestMsgpackEncodesBrokerData(t *testing.T) {
+	msg := &ServerMessage{Event: "update", Data: json.RawMessage(`{"count":3}`)}
+
+	data, err := MsgpackCodec.Marshal(msg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		Event string   `json:"event"`
+		Data  echoData `json:"data"`
+	}
+
+	if err := MsgpackCodec.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Event != "update" || decoded.Data.Count != 3 {
+		t.Errorf("expected update with count 3, got %+v", decoded)
+	}
+
+	if _, ok := msg.Data.(json.RawMessage); !ok {
+		t.Error("marshalling should not modify the message")
+	}
+}
+
+func TestMsgpackPublishKeepsBinaryData(t *testing.T) {
+	rts := NewRealtimeServer()
+	rts.RegisterChannelFactory(NewChannelFactory("room"))
+
+	srv := httptest.NewServer(rts)
+	defer srv.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"msgpack"}}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ws.Close()
+
+	writeMsgpack(t, ws, map[string]interface{}{"type": Subscribe, "channel": "room", "ref": "1"})
+
+	var reply ServerMessage
+	readMsgpack(t, ws, &reply)
+
+	rts.Publish("room", "blob", map[string]interface{}{"bytes": []byte{1, 2, 3}})
+
+	var msg ServerMessage
+	readMsgpack(t, ws, &msg)
+
+	data, _ := msg.Data.(map[string]interface{})
+
+	if b, ok := data["bytes"].([]byte); !ok || len(b) != 3 {
+		t.Errorf("binary data should arrive as binary, got %#v", data["bytes"])
+	}
+}
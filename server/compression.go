@@ -0,0 +1,29 @@
+package server
+
+import "compress/flate"
+
+// CompressionConfig controls permessage-deflate compression of outbound
+// messages.
+type CompressionConfig struct {
+	// Enabled negotiates permessage-deflate with clients that offer it
+	Enabled bool
+	// Threshold is the size in bytes below which messages are sent
+	// uncompressed, as deflating small payloads costs more than it saves
+	Threshold int
+	// Level is a compress/flate level from flate.HuffmanOnly to
+	// flate.BestCompression. Zero uses flate.DefaultCompression, as
+	// flate.NoCompression would only add deflate framing.
+	Level int
+}
+
+var DefaultCompressionConfig = CompressionConfig{
+	Enabled:   false,
+	Threshold: 512,
+	Level:     flate.DefaultCompression,
+}
+
+// Whether msg should be compressed when written to a connection that
+// negotiated compression.
+func (config CompressionConfig) compresses(msg *encodedMessage) bool {
+	return config.Enabled && !msg.uncompressed && len(msg.data) >= config.Threshold
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCompressionThreshold(t *testing.T) {
+	config := CompressionConfig{Enabled: true, Threshold: 4}
+
+	tests := []struct {
+		config   CompressionConfig
+		msg      *encodedMessage
+		expected bool
+	}{
+		{config, &encodedMessage{data: []byte("abc")}, false},
+		{config, &encodedMessage{data: []byte("abcd")}, true},
+		{config, &encodedMessage{data: []byte("abcd"), uncompressed: true}, false},
+		{CompressionConfig{Threshold: 4}, &encodedMessage{data: []byte("abcd")}, false},
+	}
+
+	for _, tt := range tests {
+		if compresses := tt.config.compresses(tt.msg); compresses != tt.expected {
+			t.Errorf("%+v compressing %q: expected %t, got %t", tt.config, tt.msg.data, tt.expected, compresses)
+		}
+	}
+}
+
+func TestWithCompressionRejectsInvalidLevel(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected invalid compression level to panic")
+		}
+	}()
+
+	WithCompression(CompressionConfig{Enabled: true, Level: 10})
+}
+
+func TestCompressedReply(t *testing.T) {
+	// The zero Level must still compress
+	rts := NewRealtimeServer(WithCompression(CompressionConfig{Enabled: true, Threshold: 64}))
+
+	payload := strings.Repeat("realtime ", 100)
+	cf := NewChannelFactory("room")
+
+	cf.HandleReply("big", func(ctx context.Context, e *Event) (interface{}, error) {
+		return payload, nil
+	})
+	rts.RegisterChannelFactory(cf)
+
+	srv := httptest.NewServer(rts)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	var read int64
+
+	dialer := websocket.Dialer{
+		EnableCompression: true,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+
+			return &countingConn{Conn: conn, read: &read}, err
+		},
+	}
+	ws, resp, err := dialer.Dial(url, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ws.Close()
+
+	if !strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		t.Fatal("expected permessage-deflate to be negotiated")
+	}
+
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: Subscribe, Channel: "room"}, Ref: "1"})
+
+	// Wait for the subscription to be confirmed
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	before := atomic.LoadInt64(&read)
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: ClientEvent, Channel: "room"}, Event: "big", Ref: "2"})
+
+	var reply ServerMessage
+
+	if err := ws.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Ref != "2" || reply.Data != payload {
+		t.Errorf("expected compressed reply to round trip, got %+v", reply)
+	}
+
+	if wire := atomic.LoadInt64(&read) - before; wire >= int64(len(payload)) {
+		t.Errorf("expected the reply to be compressed, read %d bytes for a %d byte payload", wire, len(payload))
+	}
+}
+
+// countingConn counts the bytes read from the network.
+type countingConn struct {
+	net.Conn
+	read *int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(c.read, int64(n))
+
+	return n, err
+}
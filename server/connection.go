@@ -2,42 +2,65 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-const (
-	writeWait  = 10 * time.Second
-	pongWait   = 60 * time.Second
-	pingPeriod = (pongWait * 9) / 10
-)
-
 var newLine = []byte{'\n'}
 
+var errConnectionClosed = errors.New("connection closed")
+
 type Connection struct {
 	Id uuid.UUID
 
+	// Identity resolved by the server's Authenticator, nil when none is set
+	Identity interface{}
+
 	conn *websocket.Conn
 
 	mu       sync.RWMutex
 	channels map[*Channel]bool
-	server   *RealtimeServer
+	// left is set once the connection starts leaving its channels, after
+	// which it may not join any
+	left   bool
+	server *RealtimeServer
 
 	ctx  context.Context
 	stop func()
 
-	byteSend chan []byte
+	// codec negotiated for the connection's messages
+	codec Codec
+
+	send        *sendQueue
+	closeCode   int
+	compression CompressionConfig
+
+	limiter rateLimiter
 
 	pingTicker *time.Ticker
 
 	stopper sync.Once
+
+	// closing asks the writer to flush and send a close frame
+	closing chan closeFrame
+	// closed is closed once the connection has left all of its channels
+	closed chan struct{}
+}
+
+type closeFrame struct {
+	code   int
+	reason string
 }
 
+// Close frame reasons are limited to 123 bytes by the websocket protocol
+const maxCloseReason = 123
+
 type connIdKey = string
 
 var ConnIdKey = connIdKey("connId")
@@ -46,17 +69,25 @@ func (c *Connection) String() string {
 	return fmt.Sprintf("conn:%s", c.Id)
 }
 
-func newConnection(ctx context.Context, conn *websocket.Conn, server *RealtimeServer) *Connection {
+func newConnection(ctx context.Context, conn *websocket.Conn, server *RealtimeServer, identity interface{}) *Connection {
 	c := &Connection{
-		Id:         uuid.New(),
-		conn:       conn,
-		server:     server,
-		byteSend:   make(chan []byte),
-		channels:   make(map[*Channel]bool),
-		pingTicker: time.NewTicker(pingPeriod),
+		Id:          server.newID(),
+		Identity:    identity,
+		conn:        conn,
+		server:      server,
+		codec:       server.codec(conn.Subprotocol()),
+		send:        newSendQueue(server.sendQueue),
+		closeCode:   server.sendQueue.CloseCode,
+		compression: server.compression,
+		channels:    make(map[*Channel]bool),
+		pingTicker:  time.NewTicker(server.pingPeriod),
+		closing:     make(chan closeFrame, 1),
+		closed:      make(chan struct{}),
 	}
 
-	ctx, stop := context.WithCancel(context.WithValue(ctx, ConnIdKey, c.Id))
+	ctx = context.WithValue(ctx, ConnIdKey, c.Id)
+	ctx = context.WithValue(ctx, IdentityKey, identity)
+	ctx, stop := context.WithCancel(ctx)
 
 	c.ctx = ctx
 	c.stop = stop
@@ -64,6 +95,18 @@ func newConnection(ctx context.Context, conn *websocket.Conn, server *RealtimeSe
 	return c
 }
 
+// Logs an entry about the connection through the server's logger.
+// Connections created outside a server use the default logger.
+func (c *Connection) log(level Level, msg string, fields ...Field) {
+	logger := defaultLogger
+
+	if c.server != nil {
+		logger = c.server.logger
+	}
+
+	logger.Log(level, msg, append([]Field{connField(c)}, fields...)...)
+}
+
 func (c *Connection) ConnId(ctx context.Context) any {
 	return ctx.Value(ConnIdKey)
 }
@@ -73,17 +116,16 @@ func (c *Connection) start() {
 }
 
 func (c *Connection) read() {
-	log.Printf("[%s] starting read", c)
+	c.log(LevelDebug, "starting read")
 
 	go c.write()
 
-	defer func() {
-		log.Printf("[%s] Closing conn read", c)
-	}()
+	defer c.log(LevelDebug, "read closed")
 
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetReadLimit(c.server.readLimit)
+	c.conn.SetReadDeadline(time.Now().Add(c.server.pongWait))
 	// c.conn.SetPingHandler(func(string) error {
-	// 	log.Printf("[%s] Ping received", c)
+	// 	c.log(LevelDebug, "ping received")
 	// 	if err := c.conn.WriteMessage(websocket.PongMessage, nil); err != nil {
 	// 		return err
 	// 	}
@@ -92,127 +134,327 @@ func (c *Connection) read() {
 	// })
 
 	c.conn.SetPongHandler(func(string) error {
-		log.Printf("[%s] Pong received", c)
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.log(LevelDebug, "pong received")
+		c.conn.SetReadDeadline(time.Now().Add(c.server.pongWait))
 		return nil
 	})
 
 	for {
-		msg := &ClientMessage{}
-		err := c.conn.ReadJSON(msg)
+		_, data, err := c.conn.ReadMessage()
+
+		if err == websocket.ErrReadLimit {
+			// The websocket package has already sent CloseMessageTooBig
+			c.log(LevelWarn, "message exceeded read limit", Field{Key: "limit", Value: c.server.readLimit})
+			break
+		}
 
 		if err != nil {
-			log.Printf("[%s] error %v!??????????????", c, err)
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("[%s] error %v!!!!!!!!!!!!", c, err)
+				c.log(LevelWarn, "unexpected close", errField(err))
+			} else {
+				c.log(LevelDebug, "read failed", errField(err))
 			}
 
-			log.Printf("[%s] WHAT IS HAPPENING???????", c)
 			break
 		}
 
+		msg, err := c.decode(data)
+
+		if err != nil {
+			c.log(LevelWarn, "malformed message", errField(err))
+
+			if c.server.malformed == CloseOnMalformed {
+				c.disconnect(websocket.CloseInvalidFramePayloadData, "malformed message")
+				break
+			}
+
+			serverErr := NewServerError("Malformed message", ServerErrorFields{
+				"code":   ErrCodeMalformedMessage,
+				"reason": err.Error(),
+			})
+			serverErr.Ref = msg.Ref
+			c.handleError(serverErr)
+
+			continue
+		}
+
+		if !c.allowMessage(msg, connectionLimit, "", c.server.rateLimit) {
+			continue
+		}
+
 		go c.handleMessage(c.ctx, msg)
 	}
 }
 
+// Decodes a client message with the connection's codec. The message is
+// returned with the error when it decoded but has an unknown type, so its
+// ref can be echoed.
+func (c *Connection) decode(data []byte) (*ClientMessage, error) {
+	msg := &ClientMessage{}
+
+	if err := c.codec.Unmarshal(data, msg); err != nil {
+		return &ClientMessage{}, err
+	}
+
+	if !msg.Type.fromClient() {
+		return msg, fmt.Errorf("unknown message type %q", msg.Type)
+	}
+
+	return msg, nil
+}
+
 func (c *Connection) write() {
-	log.Printf("[%s] Starting write", c)
+	c.log(LevelDebug, "starting write")
 	defer func() {
-		log.Printf("[%s] Closing writer", c)
+		c.log(LevelDebug, "write closed")
 		c.closeConnection()
 	}()
 
 	for {
 		select {
-		case byteMessage := <-c.byteSend:
-			if err := c.writeBytes(byteMessage); err != nil {
+		case <-c.send.ready:
+			if err := c.flushQueue(); err != nil {
 				return
 			}
 
+		case frame := <-c.closing:
+			c.flushQueue()
+
+			msg := websocket.FormatCloseMessage(frame.code, frame.reason)
+
+			if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(c.server.writeWait)); err != nil {
+				c.log(LevelWarn, "sending close frame failed", errField(err))
+			}
+
+			return
+
 		case <-c.pingTicker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			log.Printf("[%s] Ping sent", c)
+			c.conn.SetWriteDeadline(time.Now().Add(c.server.writeWait))
+			c.log(LevelDebug, "ping sent")
 
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("[%s] Ping message error %s", c, err)
+				c.log(LevelWarn, "ping failed", errField(err))
 				return
 			}
 
 		case <-c.ctx.Done():
-			log.Printf("DONE NOW YEAH!!!!!!!!!!! %v", c.ctx.Err())
 			return
 		}
 	}
 }
 
 func (c *Connection) closeConnection() {
-	log.Printf("[%s] Closing connection", c)
-
 	c.stopper.Do(func() {
+		c.log(LevelInfo, "connection closed")
+
 		c.stop()
 		c.pingTicker.Stop()
 		c.conn.Close()
 
+		// Subscribes still being handled are refused from here on, so every
+		// channel joined is in the snapshot
+		c.mu.Lock()
+		c.left = true
+		channels := make([]*Channel, 0, len(c.channels))
+
 		for channel := range c.channels {
+			channels = append(channels, channel)
+		}
+		c.mu.Unlock()
+
+		var leaving sync.WaitGroup
+
+		for _, channel := range channels {
 			ctx := &Event{
 				Conn:    c,
 				Channel: channel,
 			}
-			go channel.removeConnection(c.ctx, ctx)
+
+			leaving.Add(1)
+			go func(channel *Channel) {
+				defer leaving.Done()
+				channel.removeConnection(c.ctx, ctx)
+			}(channel)
 		}
 
-		close(c.byteSend)
+		c.send.close()
+		c.server.Hub.unsubscribePatterns(c)
+
+		go func() {
+			leaving.Wait()
+			close(c.closed)
+		}()
 	})
 }
 
-func (c *Connection) writeBytes(msg []byte) error {
-	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+// Asks the writer to flush queued messages, then send a close frame with
+// code and reason and close the connection.
+func (c *Connection) shutdown(code int, reason string) {
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+	}
+
+	select {
+	case c.closing <- closeFrame{code: code, reason: reason}:
+	default:
+	}
+}
+
+// Writes every queued message, stopping at the first error.
+func (c *Connection) flushQueue() error {
+	for {
+		msg, ok := c.send.pop()
 
-	w, err := c.conn.NextWriter(websocket.TextMessage)
+		if !ok {
+			return nil
+		}
 
-	if err != nil {
-		return err
+		if err := c.writePrepared(msg); err != nil {
+			return err
+		}
 	}
+}
+
+// Queues msg for the writer. Messages that overflow the send queue are
+// handled according to the server's BackpressurePolicy.
+func (c *Connection) enqueue(msg *encodedMessage) {
+	dropped, err := c.send.push(msg)
+
+	switch err {
+	case errSendQueueClosed:
+		return
+	case errSlowConsumer:
+		c.log(LevelWarn, "send queue full, disconnecting slow consumer")
+		go c.disconnect(c.closeCode, "slow consumer")
+		c.countDropped()
 
-	w.Write(msg)
+		return
+	}
 
-	if err = w.Close(); err != nil {
+	if dropped != msg && c.server != nil {
+		c.server.metrics.messageSent(msg.event)
+	}
+
+	if dropped != nil {
+		c.log(LevelWarn, "send queue full, message dropped", eventField(dropped.event))
+		c.countDropped()
+	}
+}
+
+func (c *Connection) countDropped() {
+	if c.server != nil {
+		atomic.AddUint64(&c.server.dropped, 1)
+	}
+}
+
+// Encodes v with the connection's codec and queues it.
+func (c *Connection) sendValue(v interface{}) error {
+	return c.sendPrepared(prepare(v))
+}
+
+// Queues msg, reusing its encoding when another connection with the same
+// codec has already been sent it.
+func (c *Connection) sendPrepared(msg *preparedMessage) error {
+	encoded, err := msg.encode(c.codec)
+
+	if err != nil {
 		return err
 	}
 
+	c.enqueue(encoded)
+
 	return nil
 }
 
-func (c *Connection) addChannel(channel *Channel) {
-	log.Printf("[%s] Adding channel %s to connection", c, channel.Name)
+// Dropped returns the number of messages dropped because this connection's
+// send queue was full.
+func (c *Connection) Dropped() uint64 {
+	return c.send.droppedCount()
+}
+
+// Sends a close frame with code and reason before closing the connection.
+func (c *Connection) disconnect(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+
+	if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(c.server.writeWait)); err != nil {
+		c.log(LevelWarn, "sending close frame failed", errField(err))
+	}
+
+	c.closeConnection()
+}
+
+func (c *Connection) writePrepared(msg *encodedMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.server.writeWait))
+	c.conn.EnableWriteCompression(c.compression.compresses(msg))
+
+	return c.conn.WritePreparedMessage(msg.prepared)
+}
+
+// Adds channel unless the connection has closed. Returns whether it was
+// added.
+func (c *Connection) addChannel(channel *Channel) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.left {
+		return false
+	}
+
 	c.channels[channel] = true
+
+	return true
+}
+
+// Whether the connection has closed and left its channels.
+func (c *Connection) hasLeft() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.left
 }
 
 func (c *Connection) removeChannel(channel *Channel) {
-	log.Printf("[%s] Removing channel %s from connection", c, channel.Name)
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if _, ok := c.channels[channel]; ok {
 		delete(c.channels, channel)
-		log.Printf("[%s] Channel %s removed from connection", c, channel.Name)
+		c.limiter.forget(channel.Name)
 	}
 }
 
 func (c *Connection) writeMessage(message *ServerMessage) (err error) {
-	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	c.conn.SetWriteDeadline(time.Now().Add(c.server.writeWait))
 
 	err = c.conn.WriteJSON(message)
 	return
 }
 
 func (c *Connection) handleMessage(ctx context.Context, msg *ClientMessage) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.log(LevelError, "recovered from panic", channelField(msg.Channel), Field{Key: "type", Value: msg.Type}, errField(r))
+			serverErr := NewServerError("Internal server error", ServerErrorFields{
+				"channel": msg.Channel,
+			})
+			serverErr.Ref = msg.Ref
+			c.handleError(serverErr)
+		}
+	}()
+
+	if isPattern(msg.Channel) && (msg.Type == Subscribe || msg.Type == Unsubscribe) {
+		c.handlePattern(msg)
+		return
+	}
+
+	ctx, span := c.startSpan(ctx, msg)
+	defer span.End()
+
 	var channel *Channel
 	var ok bool
 
+	_, lookup := c.server.Hub.tracer.Start(ctx, "realtime.hub.lookup")
+
 	switch msg.Type {
 	case Subscribe:
 		channel, ok = c.server.Hub.findOrOpenChannel(msg.Channel)
@@ -220,10 +462,16 @@ func (c *Connection) handleMessage(ctx context.Context, msg *ClientMessage) {
 		channel, ok = c.server.Hub.findChannel(msg.Channel)
 	}
 
+	lookup.SetAttribute("found", ok)
+	lookup.End()
+
 	if !ok {
-		c.handleError(NewServerError("Channel not found", ServerErrorFields{
+		err := NewServerError("Channel not found", ServerErrorFields{
 			"channel": msg.Channel,
-		}))
+		})
+		err.Ref = msg.Ref
+		span.RecordError(err)
+		c.handleError(err)
 		return
 	}
 
@@ -231,6 +479,73 @@ func (c *Connection) handleMessage(ctx context.Context, msg *ClientMessage) {
 	event.Channel.handleEvent(ctx, event)
 }
 
+// Starts the span covering a Subscribe or ClientEvent, continuing the
+// client's trace when its metadata carries a traceparent. Other messages are
+// not traced.
+func (c *Connection) startSpan(ctx context.Context, msg *ClientMessage) (context.Context, Span) {
+	if msg.Type != Subscribe && msg.Type != ClientEvent {
+		return ctx, noopSpan{}
+	}
+
+	if sc, ok := ParseTraceParent(msg.Metadata[TraceParentKey]); ok {
+		ctx = ContextWithRemoteSpanContext(ctx, sc)
+	}
+
+	ctx, span := c.server.Hub.tracer.Start(ctx, "realtime."+string(msg.Type))
+	span.SetAttribute("conn", c.Id.String())
+	span.SetAttribute("channel", msg.Channel)
+
+	if msg.Type == ClientEvent {
+		span.SetAttribute("event", msg.Event)
+	}
+
+	return ctx, span
+}
+
+func (c *Connection) handlePattern(msg *ClientMessage) {
+	if msg.Type == Unsubscribe {
+		c.server.Hub.unsubscribePattern(c, msg.Channel)
+		return
+	}
+
+	err := c.server.authorizePattern(c, msg.Channel)
+
+	if err == nil {
+		err = c.server.Hub.subscribePattern(c, msg.Channel)
+	}
+
+	if err != nil {
+		c.log(LevelWarn, "pattern subscription rejected", Field{Key: "pattern", Value: msg.Channel}, errField(err))
+
+		rejection, ok := err.(*Rejection)
+
+		if !ok {
+			rejection = Reject(RejectJoinFailed, err.Error())
+		}
+
+		serverErr := NewServerError(rejection.Msg, ServerErrorFields{
+			"channel": msg.Channel,
+			"code":    rejection.Code,
+		})
+		serverErr.Ref = msg.Ref
+		c.handleError(serverErr)
+
+		return
+	}
+
+	if msg.Ref != "" {
+		reply := &ServerMessage{
+			Message: Message{
+				Channel: msg.Channel,
+				Type:    ServerReply,
+			},
+			Ref: msg.Ref,
+		}
+
+		c.sendValue(reply)
+	}
+}
+
 func (c *Connection) handleError(err error) {
 	var serverErr *ServerError
 	switch err.(type) {
@@ -240,6 +555,5 @@ func (c *Connection) handleError(err error) {
 		serverErr = NewServerError(err.Error(), ServerErrorFields{})
 	}
 
-	bytes, _ := serverErr.Marshal()
-	c.byteSend <- bytes
+	c.sendValue(serverErr)
 }
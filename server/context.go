@@ -1,10 +1,15 @@
 package server
 
+import "fmt"
+
 type Event struct {
-	Channel   *Channel
-	Conn      *Connection
-	Msg       *ClientMessage
-	dataCache interface{}
+	Channel *Channel
+	Conn    *Connection
+	Msg     *ClientMessage
+
+	// Handler is the name of the handler currently running, which is the
+	// event name or one of the built-in hooks such as Join
+	Handler string
 }
 
 func NewEvent(channel *Channel, conn *Connection, msg *ClientMessage) *Event {
@@ -15,28 +20,39 @@ func NewEvent(channel *Channel, conn *Connection, msg *ClientMessage) *Event {
 	}
 }
 
+func (c *Event) String() string {
+	return fmt.Sprintf("%s:%s", c.Channel, c.Handler)
+}
+
 func (c *Event) Param(p string) string {
 	return c.Channel.Params.Param(p)
 }
 
+func (c *Event) Identity() interface{} {
+	return c.Conn.Identity
+}
+
 func (c *Event) Name() string {
 	return c.Msg.Event
 }
 
+// Ref returns the client's correlation id for this event, if any.
+func (c *Event) Ref() string {
+	if c.Msg == nil {
+		return ""
+	}
+
+	return c.Msg.Ref
+}
+
 func (c *Event) Type() ConnectionEvent {
 	return c.Msg.Type
 }
 
+// Data decodes the event's payload into v. It may be called any number of
+// times with different targets.
 func (c *Event) Data(v interface{}) error {
-	if c.dataCache != nil {
-		return nil
-	}
-
-	err := c.Msg.Data(v)
-
-	c.dataCache = v
-
-	return err
+	return c.Msg.Data(v)
 }
 
 func (c *Event) Broadcast(event string, data interface{}) {
@@ -49,23 +65,50 @@ func (c *Event) Emit(event string, data interface{}) {
 
 func (c *Event) Send(event string, data interface{}) {
 	serverMessage := c.Channel.newServerMessage(event, data)
-	bytes, err := serverMessage.Marshal()
-
-	if err != nil {
-		return
-	}
-
-	c.Conn.byteSend <- bytes
+	c.Conn.sendPrepared(c.Channel.prepare(serverMessage))
 }
 
 func (c *Event) Ack(data interface{}) {
 	serverMessage := c.Channel.newServerMessage("ack", data)
+	serverMessage.Ref = c.Ref()
+
+	c.Conn.sendPrepared(c.Channel.prepare(serverMessage))
+}
 
-	bytes, err := serverMessage.Marshal()
+// Reply answers the client's event with data, echoing the event's ref. Only
+// replies to a ClientEvent, which reached a handler, are named after it, as
+// outbound messages are labeled in metrics by their event.
+func (c *Event) Reply(data interface{}) {
+	name := ""
+
+	if c.Type() == ClientEvent {
+		name = c.Name()
+	}
+
+	serverMessage := c.Channel.newServerMessage(name, data)
+	serverMessage.Type = ServerReply
+	serverMessage.Ref = c.Ref()
+
+	if err := c.Conn.sendPrepared(c.Channel.prepare(serverMessage)); err != nil {
+		c.Error(err)
+	}
+}
 
-	if err != nil {
-		return
+// Error answers the client's event with a ServerError, echoing the event's
+// ref. Errors that are not a *ServerError are tagged with the channel and
+// event.
+func (c *Event) Error(err error) {
+	var serverErr ServerError
+
+	if se, ok := err.(*ServerError); ok {
+		serverErr = *se
+	} else {
+		serverErr = *NewServerError(err.Error(), ServerErrorFields{
+			"channel": c.Channel.Name,
+			"event":   c.Name(),
+		})
 	}
 
-	c.Conn.byteSend <- bytes
+	serverErr.Ref = c.Ref()
+	c.Conn.handleError(&serverErr)
 }
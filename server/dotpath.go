@@ -1,14 +1,81 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/google/uuid"
 )
 
+// DotPath is a channel path pattern made of segments separated by ".":
+//
+//	test.lobby          static segments
+//	test.{id}           a param matching \w+
+//	test.{id:int}       a typed param, one of int, uuid or string
+//	test.{slug:[a-z-]+} a param constrained by a regular expression
+//	test.*              any single segment, not captured
+//	test.**             one or more trailing segments, captured as "**"
+//	test.{id}?          an optional segment
 type DotPath struct {
-	path          string
-	pathRegExpStr string
-	paramCount    int
+	path       string
+	segments   []pathSegment
+	paramCount int
+	router     *router
+	err        error
+}
+
+type segmentKind int
+
+const (
+	staticSegment segmentKind = iota
+	paramSegment
+	wildcardSegment
+	catchAllSegment
+)
+
+const (
+	WildcardParam = "*"
+	CatchAllParam = "**"
+)
+
+var ErrInvalidPath = errors.New("dotpath: invalid path")
+
+type pathSegment struct {
+	kind     segmentKind
+	value    string
+	optional bool
+
+	// constraint is the param's type or regular expression, empty for \w+
+	constraint string
+	pattern    *regexp.Regexp
+}
+
+var paramTypes = map[string]func(string) bool{
+	"int":    isInt,
+	"uuid":   isUUID,
+	"string": func(s string) bool { return s != "" },
+}
+
+func (s *pathSegment) matches(segment string) bool {
+	switch s.kind {
+	case staticSegment:
+		return s.value == segment
+	case wildcardSegment:
+		return segment != ""
+	}
+
+	if s.constraint == "" {
+		return isWord(segment)
+	}
+
+	if isType, ok := paramTypes[s.constraint]; ok {
+		return isType(segment)
+	}
+
+	return segment != "" && s.pattern.MatchString(segment)
 }
 
 type Param struct {
@@ -33,6 +100,14 @@ func (p Params) Param(name string) (param string) {
 	return
 }
 
+func (p Params) Int(name string) (int, error) {
+	return strconv.Atoi(p.Param(name))
+}
+
+func (p Params) UUID(name string) (uuid.UUID, error) {
+	return uuid.Parse(p.Param(name))
+}
+
 func (p Params) Len() int {
 	return len(p)
 }
@@ -40,75 +115,242 @@ func (p Params) Len() int {
 var emptyParams = make(Params, 0)
 
 func (dp *DotPath) doesMatch(path string) (bool, *Params) {
-	if dp.paramCount == 0 {
-		return path == dp.path, &emptyParams
+	if dp.err != nil {
+		return false, nil
 	}
 
-	pathRegExp := regexp.MustCompile(dp.pathRegExpStr)
-	doesMatch := pathRegExp.MatchString(path)
+	cf, params := dp.router.lookup(path)
 
-	if !doesMatch {
-		return doesMatch, nil
+	if cf == nil {
+		return false, nil
 	}
 
-	newParams := make(Params, dp.paramCount)
+	return true, &params
+}
+
+// Err returns the error parsing the path, if any. Registering a factory with
+// an invalid path fails with this error.
+func (dp *DotPath) Err() error {
+	return dp.err
+}
+
+// NewDotPath parses path. An invalid path never matches; its error is
+// available from Err and is returned when its factory is registered.
+func NewDotPath(path string) *DotPath {
+	dp, err := ParseDotPath(path)
+
+	if err != nil {
+		return &DotPath{path: path, err: err}
+	}
+
+	return dp
+}
 
-	matches := pathRegExp.FindStringSubmatch(path)
+func ParseDotPath(path string) (*DotPath, error) {
+	parts, err := splitPath(path)
 
-	for i, name := range pathRegExp.SubexpNames() {
-		if i == 0 {
-			continue
+	if err != nil {
+		return nil, err
+	}
+
+	dp := &DotPath{path: path}
+	names := make(map[string]bool)
+
+	for i, part := range parts {
+		segment, err := parseSegment(part)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %v", ErrInvalidPath, path, err)
 		}
 
-		newParams[i-1] = Param{
-			Key:   name,
-			Value: matches[pathRegExp.SubexpIndex(name)],
+		switch segment.kind {
+		case paramSegment:
+			if names[segment.value] {
+				return nil, fmt.Errorf("%w %s: duplicate param {%s}", ErrInvalidPath, path, segment.value)
+			}
+
+			names[segment.value] = true
+			dp.paramCount++
+		case catchAllSegment:
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("%w %s: ** must be the last segment", ErrInvalidPath, path)
+			}
+
+			dp.paramCount++
 		}
+
+		dp.segments = append(dp.segments, segment)
 	}
 
-	return doesMatch, &newParams
-}
+	dp.router = newRouter()
 
-func NewDotPath(path string) *DotPath {
-	pathRegExpStr, paramCount := parsePath(path)
+	if err := dp.router.insertPath(dp, &ChannelFactory{DotPath: dp}); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidPath, path, err)
+	}
 
-	return &DotPath{path, pathRegExpStr, paramCount}
+	return dp, nil
 }
 
 const delimiter = "."
 
-func parsePath(path string) (string, int) {
-	paramReg := regexp.MustCompile(`\{\w+\}`)
+// Splits path on delimiters outside of braces, so constraints may contain
+// them.
+func splitPath(path string) ([]string, error) {
+	if path == "" {
+		return nil, fmt.Errorf("%w: empty path", ErrInvalidPath)
+	}
+
+	var parts []string
+
+	depth, start := 0, 0
+
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth < 0 {
+				return nil, fmt.Errorf("%w %s: unbalanced }", ErrInvalidPath, path)
+			}
+		case delimiter[0]:
+			if depth == 0 {
+				parts = append(parts, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("%w %s: unbalanced {", ErrInvalidPath, path)
+	}
+
+	return append(parts, path[start:]), nil
+}
+
+func parseSegment(part string) (pathSegment, error) {
+	var segment pathSegment
+
+	if strings.HasSuffix(part, "?") {
+		segment.optional = true
+		part = part[:len(part)-1]
+	}
 
-	parts := strings.Split(path, delimiter)
+	switch {
+	case part == "":
+		return segment, errors.New("empty segment")
 
-	pathRegString := ""
+	case part == WildcardParam:
+		segment.kind = wildcardSegment
+		segment.value = WildcardParam
 
-	var pathParts []string
+	case part == CatchAllParam:
+		if segment.optional {
+			return segment, errors.New("** cannot be optional")
+		}
+
+		segment.kind = catchAllSegment
+		segment.value = CatchAllParam
+
+	case part[0] == '{':
+		if part[len(part)-1] != '}' {
+			return segment, fmt.Errorf("malformed param %s", part)
+		}
+
+		segment.kind = paramSegment
+		segment.value = part[1 : len(part)-1]
 
-	paramCount := 0
+		if i := strings.Index(segment.value, ":"); i >= 0 {
+			segment.constraint = segment.value[i+1:]
+			segment.value = segment.value[:i]
+		}
 
-	for _, part := range parts {
-		isParam := paramReg.MatchString(part)
+		if !isWord(segment.value) {
+			return segment, fmt.Errorf("invalid param name in %s", part)
+		}
+
+		if _, isType := paramTypes[segment.constraint]; segment.constraint != "" && !isType {
+			pattern, err := regexp.Compile("^(?:" + segment.constraint + ")$")
+
+			if err != nil {
+				return segment, fmt.Errorf("invalid constraint in %s: %v", part, err)
+			}
+
+			segment.pattern = pattern
+		}
 
-		if isParam {
-			pathParts = append(pathParts, getRegExpStrForParam(part))
-			paramCount = paramCount + 1
-		} else {
-			pathParts = append(pathParts, part)
-			pathRegString += part
+	default:
+		if strings.ContainsAny(part, "{}*") {
+			return segment, fmt.Errorf("malformed segment %s", part)
 		}
+
+		segment.kind = staticSegment
+		segment.value = part
 	}
 
-	pathReg := strings.Join(pathParts, "\\.")
+	return segment, nil
+}
+
+// Returns every combination of segments with optional segments included or
+// left out.
+func (dp *DotPath) variants() [][]pathSegment {
+	variants := [][]pathSegment{nil}
 
-	return "^" + pathReg + "$", paramCount
+	for _, segment := range dp.segments {
+		next := make([][]pathSegment, 0, len(variants)*2)
+
+		for _, variant := range variants {
+			included := append(append([]pathSegment{}, variant...), segment)
+			next = append(next, included)
+
+			if segment.optional {
+				next = append(next, variant)
+			}
+		}
+
+		variants = next
+	}
+
+	return variants
 }
 
-func getRegExpStrForParam(param string) string {
-	paramNameReg := regexp.MustCompile(`\w+`)
+func isInt(s string) bool {
+	if s != "" && s[0] == '-' {
+		s = s[1:]
+	}
 
-	paramName := paramNameReg.FindString(param)
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Whether s is a canonical, hyphenated UUID.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				return false
+			}
+		}
+	}
 
-	return "(?P<" + paramName + ">\\w+)"
+	return true
 }
@@ -63,3 +63,93 @@ func TestDotPathParams(t *testing.T) {
 		t.Errorf("otherParam param should be %s", "other")
 	}
 }
+
+func TestDotPathTypedParams(t *testing.T) {
+	tests := []struct {
+		path    string
+		name    string
+		matches bool
+	}{
+		{"user.{id:uuid}", "user.5a2e1d3c-8f4b-4c6a-9e2d-1b3c5d7e9f01", true},
+		{"user.{id:uuid}", "user.not-a-uuid", false},
+		{"page.{n:int}", "page.42", true},
+		{"page.{n:int}", "page.four", false},
+		{"post.{slug:[a-z-]+}", "post.hello-world", true},
+		{"post.{slug:[a-z-]+}", "post.Hello", false},
+		{"any.{s:string}", "any.a:b-c", true},
+	}
+
+	for _, tt := range tests {
+		dp := NewDotPath(tt.path)
+
+		if err := dp.Err(); err != nil {
+			t.Fatalf("%s should parse: %v", tt.path, err)
+		}
+
+		if matches, _ := dp.doesMatch(tt.name); matches != tt.matches {
+			t.Errorf("%s matching %s should be %v", tt.path, tt.name, tt.matches)
+		}
+	}
+
+	_, params := NewDotPath("page.{n:int}").doesMatch("page.42")
+
+	if n, err := params.Int("n"); n != 42 || err != nil {
+		t.Errorf("n should be 42, got %d %v", n, err)
+	}
+}
+
+func TestDotPathWildcards(t *testing.T) {
+	dp := NewDotPath("orders.*.status")
+
+	if matches, _ := dp.doesMatch("orders.1.status"); !matches {
+		t.Error("orders.1.status should match orders.*.status")
+	}
+
+	if matches, _ := dp.doesMatch("orders.1.2.status"); matches {
+		t.Error("* should match a single segment")
+	}
+
+	dp = NewDotPath("logs.**")
+
+	matches, params := dp.doesMatch("logs.app.web.error")
+
+	if !matches || params.Param(CatchAllParam) != "app.web.error" {
+		t.Errorf("logs.** should capture app.web.error, got %v", params)
+	}
+
+	if matches, _ := dp.doesMatch("logs"); matches {
+		t.Error("** should match at least one segment")
+	}
+}
+
+func TestDotPathOptionalSegments(t *testing.T) {
+	dp := NewDotPath("room.{id}.{thread}?")
+
+	if matches, params := dp.doesMatch("room.1"); !matches || params.Param("id") != "1" {
+		t.Error("room.1 should match without the optional segment")
+	}
+
+	if matches, params := dp.doesMatch("room.1.2"); !matches || params.Param("thread") != "2" {
+		t.Error("room.1.2 should match with the optional segment")
+	}
+}
+
+func TestDotPathParseErrors(t *testing.T) {
+	paths := []string{
+		"",
+		"test..channel",
+		"test.{id",
+		"test.{id}}",
+		"test.{id}.{id}",
+		"test.**.channel",
+		"test.{id:[a-}",
+		"test.{}",
+		"test.{id}?.{other}?",
+	}
+
+	for _, path := range paths {
+		if _, err := ParseDotPath(path); err == nil {
+			t.Errorf("%q should fail to parse", path)
+		}
+	}
+}
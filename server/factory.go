@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 type ChannelFactory struct {
@@ -10,10 +11,26 @@ type ChannelFactory struct {
 
 	mu       sync.Mutex
 	handlers map[string]channelEntry
+	presence bool
+
+	// uncompressed opts the factory's channels out of compression
+	uncompressed bool
+
+	middleware []Middleware
+
+	historySize   int
+	historyMaxAge time.Duration
+
+	rateLimit   RateLimit
+	eventLimits map[string]RateLimit
 }
 
 type ChannelEventHandler func(context.Context, *Event) error
 
+// ChannelReplyHandler returns the value sent back to the client as a reply to
+// its event, or an error sent back as a ServerError.
+type ChannelReplyHandler func(context.Context, *Event) (interface{}, error)
+
 type channelEntry struct {
 	handler ChannelEventHandler
 	event   string
@@ -47,6 +64,32 @@ func (cf *ChannelFactory) Handle(event string, handler ChannelEventHandler) {
 	cf.handlers[event] = entry
 }
 
+// HandleReply registers a handler whose return value is automatically sent
+// back to the client as a ServerReply carrying the event's ref.
+func (cf *ChannelFactory) HandleReply(event string, handler ChannelReplyHandler) {
+	if handler == nil {
+		panic("cf: nil handler")
+	}
+
+	cf.Handle(event, func(ctx context.Context, e *Event) error {
+		data, err := handler(ctx, e)
+
+		if err != nil {
+			return err
+		}
+
+		e.Reply(data)
+
+		return nil
+	})
+}
+
+// Authorize registers a hook that runs before BeforeJoin on every Subscribe.
+// Returning an error, typically a *Rejection, refuses the subscription.
+func (cf *ChannelFactory) Authorize(handler ChannelEventHandler) {
+	cf.Handle(Authorize, handler)
+}
+
 func (cf *ChannelFactory) BeforeJoin(handler ChannelEventHandler) {
 	cf.Handle(BeforeJoin, handler)
 }
@@ -63,8 +106,90 @@ func (cf *ChannelFactory) Leave(handler ChannelEventHandler) {
 	cf.Handle(Leave, handler)
 }
 
-func (cf *ChannelFactory) newChannel(name string, params *Params, hub *Hub) *Channel {
-	return newChannel(name, params, cf, hub)
+// TrackPresence enables presence for channels opened by this factory. The data
+// sent with Subscribe is published as the connection's presence metadata.
+// Connections are listed under their identity's PresenceKey, if it has one,
+// or their connection id.
+func (cf *ChannelFactory) TrackPresence() {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	cf.presence = true
+}
+
+// RetainHistory keeps the last size messages emitted on each channel, or those
+// younger than maxAge, so subscribers can replay them with Subscribe's since.
+// Zero disables either limit. History outlives the channel, recording
+// messages published while nobody on this node is subscribed, until it ages
+// out.
+func (cf *ChannelFactory) RetainHistory(size int, maxAge time.Duration) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	cf.historySize = size
+	cf.historyMaxAge = maxAge
+}
+
+// DisableCompression sends every message on this factory's channels
+// uncompressed, for payloads that are already compressed such as images.
+func (cf *ChannelFactory) DisableCompression() {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	cf.uncompressed = true
+}
+
+// LimitRate limits the events each connection may send to each of this
+// factory's channels. Events over the limit are answered with a ServerError.
+func (cf *ChannelFactory) LimitRate(limit RateLimit) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	cf.rateLimit = limit
+}
+
+// LimitEventRate limits how often each connection may send event to each of
+// this factory's channels, on top of any LimitRate.
+func (cf *ChannelFactory) LimitEventRate(event string, limit RateLimit) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	if cf.eventLimits == nil {
+		cf.eventLimits = make(map[string]RateLimit)
+	}
+
+	cf.eventLimits[event] = limit
+}
+
+func (cf *ChannelFactory) retainsHistory() bool {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	return cf.historySize > 0 || cf.historyMaxAge > 0
+}
+
+func (cf *ChannelFactory) newHistory() *history {
+	return newHistory(cf.historySize, cf.historyMaxAge)
+}
+
+func (cf *ChannelFactory) newChannel(name string, params *Params, hub *Hub, h *history) *Channel {
+	return newChannel(name, params, cf, hub, h)
+}
+
+// Use adds middleware wrapping every handler of this factory, inside any
+// middleware added with RealtimeServer.Use.
+func (cf *ChannelFactory) Use(mw ...Middleware) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	cf.middleware = append(cf.middleware, mw...)
+}
+
+func (cf *ChannelFactory) wrap(handler ChannelEventHandler) ChannelEventHandler {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	return chainMiddleware(handler, cf.middleware)
 }
 
 func (cf *ChannelFactory) handler(handlerName string) (channelEntry, bool) {
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+type historyEntry struct {
+	at  time.Time
+	seq uint64
+	msg *preparedMessage
+}
+
+// history stamps sequence numbers on a channel's outgoing messages and
+// optionally retains the most recent of them for replay.
+type history struct {
+	mu      sync.Mutex
+	seq     uint64
+	size    int
+	maxAge  time.Duration
+	entries []historyEntry
+}
+
+func newHistory(size int, maxAge time.Duration) *history {
+	return &history{
+		size:   size,
+		maxAge: maxAge,
+	}
+}
+
+func (h *history) retains() bool {
+	return h.size > 0 || h.maxAge > 0
+}
+
+// Stamps msg with the next sequence number, unless the broker numbered it
+// already, and prepares it, retaining the prepared message so replays reuse
+// its encodings. Callers must hold h.mu.
+func (h *history) record(msg *ServerMessage) *preparedMessage {
+	if msg.Seq == 0 {
+		h.seq++
+		msg.Seq = h.seq
+	} else if msg.Seq > h.seq {
+		h.seq = msg.Seq
+	}
+
+	prepared := prepare(msg)
+
+	if h.retains() {
+		h.entries = append(h.entries, historyEntry{at: time.Now(), seq: msg.Seq, msg: prepared})
+		h.trim(time.Now())
+	}
+
+	return prepared
+}
+
+// Returns the retained messages with a sequence number greater than seq.
+// Callers must hold h.mu.
+func (h *history) since(seq uint64) []*preparedMessage {
+	h.trim(time.Now())
+
+	var missed []*preparedMessage
+
+	for _, entry := range h.entries {
+		if entry.seq > seq {
+			missed = append(missed, entry.msg)
+		}
+	}
+
+	return missed
+}
+
+func (h *history) trim(now time.Time) {
+	start := 0
+
+	if h.size > 0 && len(h.entries) > h.size {
+		start = len(h.entries) - h.size
+	}
+
+	if h.maxAge > 0 {
+		for start < len(h.entries) && now.Sub(h.entries[start].at) > h.maxAge {
+			start++
+		}
+	}
+
+	if start > 0 {
+		h.entries = append(h.entries[:0:0], h.entries[start:]...)
+	}
+}
+
+// Whether the history is still worth keeping once its channel has closed.
+func (h *history) expired() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.trim(time.Now())
+
+	return len(h.entries) == 0
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryRetainsLastN(t *testing.T) {
+	h := newHistory(2, 0)
+
+	for i := 0; i < 3; i++ {
+		h.record(&ServerMessage{Event: "test"})
+	}
+
+	if h.seq != 3 {
+		t.Errorf("sequence should be 3, got %d", h.seq)
+	}
+
+	if missed := h.since(0); len(missed) != 2 {
+		t.Errorf("should retain 2 messages, got %d", len(missed))
+	}
+
+	if missed := h.since(2); len(missed) != 1 {
+		t.Errorf("should replay 1 message since seq 2, got %d", len(missed))
+	}
+}
+
+func TestHistoryExpiresByAge(t *testing.T) {
+	h := newHistory(0, time.Minute)
+	h.record(&ServerMessage{Event: "test"})
+
+	h.entries[0].at = time.Now().Add(-2 * time.Minute)
+
+	if !h.expired() {
+		t.Error("history older than max age should be expired")
+	}
+}
+
+func TestHistoryWithoutRetentionStillStamps(t *testing.T) {
+	h := newHistory(0, 0)
+	msg := &ServerMessage{Event: "test"}
+	h.record(msg)
+
+	if msg.Seq != 1 {
+		t.Errorf("message should be stamped with seq 1, got %d", msg.Seq)
+	}
+
+	if len(h.since(0)) != 0 {
+		t.Error("history without retention should not replay")
+	}
+}
@@ -1,48 +1,107 @@
 package server
 
 import (
-	"log"
+	"container/list"
+	"errors"
 	"sync"
+	"time"
 )
 
+var ErrChannelNotFound = errors.New("hub: no channel factory matches channel")
+
 type Hub struct {
 	mu               sync.RWMutex
 	channelsCache    map[string]*Channel
 	channelFactories map[string]*ChannelFactory
+	router           *router
+
+	// streams of open channels, and of closed channels whose history is still
+	// retained, by channel name
+	streams map[string]*stream
+	// parked lists the streams of closed channels, longest parked first
+	parked       *list.List
+	historyLimit int
+	sweptAt      time.Time
+	// released holds the broker unsubscribes of streams dropped while mu is
+	// held, called by unlock
+	released []func()
+
+	broker Broker
+
+	middleware []Middleware
+
+	patternsMu     sync.RWMutex
+	patterns       map[string]*patternSubscription
+	unsubscribeAll func()
+
+	metrics *metrics
+	logger  Logger
+	tracer  Tracer
 }
 
 func newHub() *Hub {
 	return &Hub{
 		channelsCache:    make(map[string]*Channel),
 		channelFactories: make(map[string]*ChannelFactory),
+		router:           newRouter(),
+		streams:          make(map[string]*stream),
+		parked:           list.New(),
+		historyLimit:     DefaultHistoryLimit,
+		broker:           NewMemoryBroker(),
+		patterns:         make(map[string]*patternSubscription),
+		logger:           defaultLogger,
+		tracer:           noopTracer{},
 	}
 }
 
-func (h *Hub) registerChannelFactory(cf *ChannelFactory) {
-	log.Printf("[hub] Registering channelfactory %s", cf.path)
+func (h *Hub) registerChannelFactory(cf *ChannelFactory) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	if _, ok := h.channelFactories[cf.path]; ok {
-		return
+	if err := h.router.insert(cf); err != nil {
+		return err
 	}
 
 	h.channelFactories[cf.path] = cf
+	h.logger.Log(LevelInfo, "channel factory registered", pathField(cf.path))
+
+	return nil
 }
 
-func (h *Hub) findChannel(channelName string) (*Channel, bool) {
-	log.Printf("[hub] Finding channel: %s", channelName)
+func (h *Hub) use(mw ...Middleware) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
+	h.middleware = append(h.middleware, mw...)
+}
+
+func (h *Hub) wrap(handler ChannelEventHandler) ChannelEventHandler {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return chainMiddleware(handler, h.middleware)
+}
+
+func (h *Hub) findChannel(channelName string) (*Channel, bool) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
 	if channel, ok := h.channelsCache[channelName]; ok {
-		log.Printf("[hub] Found channel: %s (%s)", channel.Name, channel.Path)
 		return channel, ok
 	}
 
-	log.Printf("[hub] Could not find channel in cache: %s", channelName)
 	return nil, false
 }
 
+// Whether channel is still the open channel for its name. Channels close
+// with their mu held, so callers holding it see a stable answer.
+func (h *Hub) isOpen(channel *Channel) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.channelsCache[channel.Name] == channel
+}
+
 func (h *Hub) findOrOpenChannel(channelName string) (*Channel, bool) {
 	if channel, ok := h.findChannel(channelName); ok {
 		return channel, ok
@@ -52,8 +111,6 @@ func (h *Hub) findOrOpenChannel(channelName string) (*Channel, bool) {
 }
 
 func (h *Hub) openChannel(channelName string) (*Channel, bool) {
-	log.Printf("[hub] Opening Channel %s", channelName)
-
 	channelFactory, params, ok := h.findChannelFactory(channelName)
 
 	if channelFactory == nil {
@@ -61,39 +118,112 @@ func (h *Hub) openChannel(channelName string) (*Channel, bool) {
 	}
 
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	defer h.unlock()
+
+	// Another Subscribe may have opened the channel since it was looked up
+	if channel, ok := h.channelsCache[channelName]; ok {
+		return channel, true
+	}
 
-	channel := channelFactory.newChannel(channelName, params, h)
+	stream, err := h.stream(channelName, channelFactory)
+
+	if err != nil {
+		h.logger.Log(LevelError, "broker subscribe failed", channelField(channelName), errField(err))
+		return nil, false
+	}
+
+	// Or while h.mu was released to subscribe
+	if channel, ok := h.channelsCache[channelName]; ok {
+		return channel, true
+	}
+
+	h.unpark(stream)
+	channel := channelFactory.newChannel(channelName, params, h, stream.history)
+
+	stream.channel = channel
 	h.channelsCache[channelName] = channel
+	h.metrics.channelOpened(channelFactory.path)
+	h.logger.Log(LevelInfo, "channel opened", channel.logFields()...)
 
 	return channel, true
 }
 
-func (h *Hub) closeChannel(channel *Channel) {
-	log.Println("[hub] Closing channel", channel.Name)
+// Publishes an event to channelName through the broker so subscribers on
+// every node receive it. Returns whether the channel has subscribers on this
+// node. Channels that retain history record the event even when they are not
+// open on this node.
+func (h *Hub) publish(channelName string, event string, data interface{}) (bool, error) {
+	cf, _, ok := h.findChannelFactory(channelName)
 
+	if !ok {
+		return false, ErrChannelNotFound
+	}
+
+	if cf.retainsHistory() {
+		if err := h.retainStream(channelName, cf); err != nil {
+			return false, err
+		}
+	}
+
+	msg, err := newBrokerMessage(channelName, event, data)
+
+	if err != nil {
+		return false, err
+	}
+
+	if err := h.broker.Publish(msg); err != nil {
+		return false, err
+	}
+
+	if channel, ok := h.findChannel(channelName); ok {
+		return channel.SubscriberCount() > 0, nil
+	}
+
+	return false, nil
+}
+
+func (h *Hub) closeChannel(channel *Channel) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	defer h.unlock()
+
+	if h.channelsCache[channel.Name] != channel {
+		return
+	}
 
 	delete(h.channelsCache, channel.Name)
-	log.Println("[hub] Channel closed", channel.Name)
-	log.Printf("[hub] %d Channels remaning", len(h.channelsCache))
+	h.park(h.streams[channel.Name], time.Now())
+	h.metrics.channelClosed(channel.factory.path)
+
+	h.logger.Log(LevelInfo, "channel closed", channel.logFields(Field{Key: "open", Value: len(h.channelsCache)})...)
 }
 
-func (h *Hub) findChannelFactory(channelName string) (*ChannelFactory, *Params, bool) {
-	log.Printf("[hub] Finding channel factory for channel: %s", channelName)
+// Makes sure channelName has a stream recording its history, parking a new
+// one when the channel is not open on this node.
+func (h *Hub) retainStream(channelName string, cf *ChannelFactory) error {
+	h.mu.Lock()
+	defer h.unlock()
+
+	stream, err := h.stream(channelName, cf)
+
+	if err != nil {
+		return err
+	}
+
+	// Streams that are neither open nor parked were just subscribed
+	if stream.channel == nil && stream.parked == nil {
+		h.park(stream, time.Now())
+	}
+
+	return nil
+}
 
+func (h *Hub) findChannelFactory(channelName string) (*ChannelFactory, *Params, bool) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	for _, cf := range h.channelFactories {
-		if doesMatch, params := cf.doesMatch(channelName); doesMatch {
-			log.Printf("[hub] Found channel factory for channel: %s (%s)", channelName, cf.path)
-			return cf, params, true
-		}
+	if cf, params := h.router.lookup(channelName); cf != nil {
+		return cf, &params, true
 	}
 
-	log.Printf("[hub] Could not find matching channel factory %s", channelName)
-
 	return nil, nil, false
 }
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHubPublish(t *testing.T) {
+	hub := newHub()
+	hub.registerChannelFactory(NewChannelFactory("orders.{id}"))
+
+	if _, err := hub.publish("unknown", "created", nil); err != ErrChannelNotFound {
+		t.Errorf("publishing to unmatched channel should fail, got %v", err)
+	}
+
+	if ok, err := hub.publish("orders.1", "created", nil); ok || err != nil {
+		t.Errorf("publishing to unopened channel should report no subscribers, got %v %v", ok, err)
+	}
+
+	channel, _ := hub.findOrOpenChannel("orders.1")
+	conn := newTestConnection()
+	channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+		Message: Message{Type: Subscribe, Channel: "orders.1"},
+	}))
+
+	ok, err := hub.publish("orders.1", "created", map[string]string{"id": "1"})
+
+	if !ok || err != nil {
+		t.Fatalf("publishing to subscribed channel should succeed, got %v %v", ok, err)
+	}
+
+	var msg ServerMessage
+	json.Unmarshal(nextMessage(t, conn), &msg)
+
+	if msg.Event != "created" || msg.Channel != "orders.1" {
+		t.Errorf("unexpected message %#v", msg)
+	}
+}
+
+func TestHubRetainsHistoryWhileChannelClosed(t *testing.T) {
+	hub := newHub()
+	cf := NewChannelFactory("orders.{id}")
+	cf.RetainHistory(10, 0)
+	hub.registerChannelFactory(cf)
+
+	subscribe := func(since *uint64) *Connection {
+		channel, _ := hub.findOrOpenChannel("orders.1")
+		conn := newTestConnection()
+		channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+			Message: Message{Type: Subscribe, Channel: "orders.1"},
+			Since:   since,
+		}))
+
+		return conn
+	}
+
+	// Published before the channel ever opened on this node
+	hub.publish("orders.1", "created", nil)
+
+	conn := subscribe(nil)
+	hub.publish("orders.1", "paid", nil)
+	nextMessage(t, conn)
+
+	channel, _ := hub.findChannel("orders.1")
+	channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+		Message: Message{Type: Unsubscribe, Channel: "orders.1"},
+	}))
+
+	if _, ok := hub.findChannel("orders.1"); ok {
+		t.Fatal("channel should close with its last subscriber")
+	}
+
+	hub.publish("orders.1", "shipped", nil)
+
+	since := uint64(1)
+	conn = subscribe(&since)
+
+	for _, event := range []string{"paid", "shipped"} {
+		var msg ServerMessage
+		json.Unmarshal(nextMessage(t, conn), &msg)
+
+		if msg.Event != event {
+			t.Errorf("expected %s to be replayed, got %#v", event, msg)
+		}
+	}
+
+	if conn.send.len() != 0 {
+		t.Error("only messages since seq 1 should be replayed")
+	}
+}
+
+func TestHubSweepsParkedHistories(t *testing.T) {
+	hub := newHub()
+	hub.historyLimit = 1
+
+	sized := NewChannelFactory("orders.{id}")
+	sized.RetainHistory(10, 0)
+	hub.registerChannelFactory(sized)
+
+	aged := NewChannelFactory("events.{id}")
+	aged.RetainHistory(0, time.Minute)
+	hub.registerChannelFactory(aged)
+
+	for _, name := range []string{"orders.1", "orders.2"} {
+		hub.publish(name, "created", nil)
+	}
+
+	if _, ok := hub.streams["orders.1"]; ok || len(hub.streams) != 1 {
+		t.Errorf("the longest parked history should be dropped beyond the limit, got %v", hub.streams)
+	}
+
+	hub.historyLimit = DefaultHistoryLimit
+	hub.publish("events.1", "created", nil)
+
+	stream := hub.streams["events.1"]
+	stream.parkedAt = time.Now().Add(-2 * time.Minute)
+	stream.history.entries[0].at = time.Now().Add(-2 * time.Minute)
+
+	hub.mu.Lock()
+	hub.sweep(time.Now().Add(historySweepInterval))
+	hub.mu.Unlock()
+
+	if _, ok := hub.streams["events.1"]; ok {
+		t.Error("history older than its max age should be swept")
+	}
+
+	if _, ok := hub.streams["orders.2"]; !ok {
+		t.Error("histories limited by size should not expire")
+	}
+}
+
+func TestHubOpensChannelOnce(t *testing.T) {
+	hub := newHub()
+	hub.registerChannelFactory(NewChannelFactory("room.{id}"))
+
+	first, _ := hub.openChannel("room.1")
+	second, _ := hub.openChannel("room.1")
+
+	if first != second {
+		t.Error("opening an open channel should return it rather than replace it")
+	}
+}
+
+func TestSubscribeToClosedChannelJoinsReopened(t *testing.T) {
+	hub := newHub()
+	hub.registerChannelFactory(NewChannelFactory("room.{id}"))
+
+	stale, _ := hub.findOrOpenChannel("room.1")
+	stale.closeIfEmpty()
+
+	conn := newTestConnection()
+	stale.handleEvent(context.Background(), NewEvent(stale, conn, &ClientMessage{
+		Message: Message{Type: Subscribe, Channel: "room.1"},
+	}))
+
+	channel, ok := hub.findChannel("room.1")
+
+	if !ok || channel == stale || !channel.hasConnection(conn) {
+		t.Fatal("subscribing to a closed channel should join the reopened channel")
+	}
+
+	hub.publish("room.1", "hello", nil)
+
+	var msg ServerMessage
+	json.Unmarshal(nextMessage(t, conn), &msg)
+
+	if msg.Event != "hello" {
+		t.Errorf("expected hello, got %#v", msg)
+	}
+}
+
+// blockingBroker blocks subscribing to one channel until released.
+type blockingBroker struct {
+	*MemoryBroker
+	channel  string
+	blocked  chan struct{}
+	released chan struct{}
+}
+
+func (b *blockingBroker) Subscribe(channel string, handler BrokerHandler) (func(), error) {
+	if channel == b.channel {
+		close(b.blocked)
+		<-b.released
+	}
+
+	return b.MemoryBroker.Subscribe(channel, handler)
+}
+
+func TestHubSubscribesOutsideLock(t *testing.T) {
+	hub := newHub()
+	hub.registerChannelFactory(NewChannelFactory("room.{id}"))
+
+	broker := &blockingBroker{
+		MemoryBroker: NewMemoryBroker(),
+		channel:      "room.slow",
+		blocked:      make(chan struct{}),
+		released:     make(chan struct{}),
+	}
+	hub.broker = broker
+
+	slow := make(chan *Channel)
+	go func() {
+		channel, _ := hub.openChannel("room.slow")
+		slow <- channel
+	}()
+	<-broker.blocked
+
+	opened := make(chan struct{})
+	go func() {
+		channel, _ := hub.openChannel("room.fast")
+		channel.closeIfEmpty()
+		close(opened)
+	}()
+
+	select {
+	case <-opened:
+	case <-time.After(time.Second):
+		t.Fatal("a slow broker subscribe should not block other channels")
+	}
+
+	close(broker.released)
+
+	channel := <-slow
+
+	if again, _ := hub.findOrOpenChannel("room.slow"); channel == nil || again != channel {
+		t.Error("expected the slow channel to open once subscribed")
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialTestServer(t *testing.T, rts *RealtimeServer) *websocket.Conn {
+	t.Helper()
+
+	srv := httptest.NewServer(rts)
+	t.Cleanup(srv.Close)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { ws.Close() })
+
+	return ws
+}
+
+func expectClose(t *testing.T, ws *websocket.Conn, code int) {
+	t.Helper()
+
+	for {
+		_, _, err := ws.ReadMessage()
+
+		if err == nil {
+			continue
+		}
+
+		if closeErr, ok := err.(*websocket.CloseError); !ok || closeErr.Code != code {
+			t.Errorf("expected close code %d, got %v", code, err)
+		}
+
+		return
+	}
+}
+
+func TestReadLimitClosesConnection(t *testing.T) {
+	ws := dialTestServer(t, NewRealtimeServer(WithReadLimit(64)))
+
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: ClientEvent, Channel: strings.Repeat("a", 100)}})
+
+	expectClose(t, ws, websocket.CloseMessageTooBig)
+}
+
+func TestMalformedMessageClosesConnection(t *testing.T) {
+	ws := dialTestServer(t, NewRealtimeServer())
+
+	ws.WriteMessage(websocket.TextMessage, []byte("not json"))
+
+	expectClose(t, ws, websocket.CloseInvalidFramePayloadData)
+}
+
+func TestRejectMalformedKeepsConnectionOpen(t *testing.T) {
+	rts := NewRealtimeServer(WithMalformedMessagePolicy(RejectMalformed))
+	rts.RegisterChannelFactory(NewChannelFactory("room"))
+
+	ws := dialTestServer(t, rts)
+
+	ws.WriteMessage(websocket.TextMessage, []byte("not json"))
+	ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"ServerEvent","channel":"room","ref":"1"}`))
+
+	for _, ref := range []string{"", "1"} {
+		var serverErr ServerError
+
+		if err := ws.ReadJSON(&serverErr); err != nil {
+			t.Fatal(err)
+		}
+
+		data, _ := serverErr.Data.(map[string]interface{})
+
+		if serverErr.Type != ServerErrorMessageType || data["code"] != ErrCodeMalformedMessage || serverErr.Ref != ref {
+			t.Errorf("expected malformed message error with ref %q, got %+v", ref, serverErr)
+		}
+	}
+
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: Subscribe, Channel: "room"}, Ref: "2"})
+
+	var reply ServerMessage
+
+	if err := ws.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Type != ServerReply || reply.Ref != "2" {
+		t.Errorf("connection should stay open after malformed messages, got %+v", reply)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Field is a key and value attached to a log entry. Entries about a
+// connection, channel or event carry the conn, channel, path and event keys.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives the server's structured log entries. Implementations must
+// be safe for concurrent use.
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// LoggerFunc adapts a function to a Logger, for example to forward entries to
+// another logging library.
+type LoggerFunc func(level Level, msg string, fields ...Field)
+
+func (f LoggerFunc) Log(level Level, msg string, fields ...Field) {
+	f(level, msg, fields...)
+}
+
+// NopLogger discards every entry.
+var NopLogger Logger = LoggerFunc(func(Level, string, ...Field) {})
+
+type stdLogger struct {
+	out *log.Logger
+	min Level
+}
+
+// NewLogger writes entries at or above min to out as key=value pairs:
+//
+//	level=info msg="channel opened" channel=room.1 path=room.{id}
+func NewLogger(out *log.Logger, min Level) Logger {
+	return &stdLogger{out: out, min: min}
+}
+
+func (l *stdLogger) Log(level Level, msg string, fields ...Field) {
+	if level < l.min {
+		return
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "level=%s msg=%q", level, msg)
+
+	for _, field := range fields {
+		value := fmt.Sprint(field.Value)
+
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+
+		fmt.Fprintf(&b, " %s=%s", field.Key, value)
+	}
+
+	l.out.Print(b.String())
+}
+
+var defaultLogger = NewLogger(log.Default(), LevelInfo)
+
+func connField(c *Connection) Field {
+	return Field{Key: "conn", Value: c.Id}
+}
+
+func channelField(name string) Field {
+	return Field{Key: "channel", Value: name}
+}
+
+func pathField(path string) Field {
+	return Field{Key: "path", Value: path}
+}
+
+func eventField(event string) Field {
+	return Field{Key: "event", Value: event}
+}
+
+func errField(err interface{}) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// Fields identifying the channel in entries about it.
+func (c *Channel) logFields(fields ...Field) []Field {
+	return append([]Field{channelField(c.Name), pathField(c.Path)}, fields...)
+}
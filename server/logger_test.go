@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(log.New(&buf, "", 0), LevelInfo)
+
+	logger.Log(LevelDebug, "hidden")
+	logger.Log(LevelWarn, "handler failed", channelField("room.1"), eventField("ping"), errField(errors.New("bad input")))
+
+	expected := "level=warn msg=\"handler failed\" channel=room.1 event=ping error=\"bad input\"\n"
+
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+type recordedEntry struct {
+	level  Level
+	msg    string
+	fields map[string]interface{}
+}
+
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []recordedEntry
+}
+
+func (l *recordingLogger) Log(level Level, msg string, fields ...Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := recordedEntry{level: level, msg: msg, fields: make(map[string]interface{})}
+
+	for _, field := range fields {
+		entry.fields[field.Key] = field.Value
+	}
+
+	l.entries = append(l.entries, entry)
+}
+
+func (l *recordingLogger) find(msg string) (recordedEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, entry := range l.entries {
+		if entry.msg == msg {
+			return entry, true
+		}
+	}
+
+	return recordedEntry{}, false
+}
+
+func TestWithLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	rts := NewRealtimeServer(WithLogger(logger))
+	rts.RegisterChannelFactory(NewChannelFactory("room.{id}"))
+
+	ws := dialTestServer(t, rts)
+
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: Subscribe, Channel: "room.1"}, Ref: "1"})
+
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	registered, _ := logger.find("channel factory registered")
+
+	if registered.fields["path"] != "room.{id}" {
+		t.Errorf("expected factory registration with path, got %+v", registered)
+	}
+
+	opened, _ := logger.find("channel opened")
+
+	if opened.level != LevelInfo || opened.fields["channel"] != "room.1" || opened.fields["path"] != "room.{id}" {
+		t.Errorf("expected channel opened entry with channel and path, got %+v", opened)
+	}
+
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		if entry, ok := logger.find("connection opened"); ok {
+			if entry.fields["conn"] == nil || entry.fields["codec"] != "json" {
+				t.Errorf("expected connection opened entry with conn and codec, got %+v", entry)
+			}
+
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected connection opened entry")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -10,8 +10,19 @@ const (
 	ClientEvent                            = "ClientEvent"
 	ServerEvent                            = "ServerEvent"
 	ServerErrorMessageType                 = "ServerError"
+	ServerReply                            = "ServerReply"
 )
 
+// Whether t is a message type clients may send.
+func (t ConnectionEvent) fromClient() bool {
+	switch t {
+	case Subscribe, Unsubscribe, ClientEvent:
+		return true
+	}
+
+	return false
+}
+
 type Message struct {
 	Type    ConnectionEvent `json:"type"`
 	Channel string          `json:"channel"`
@@ -21,12 +32,30 @@ type ClientMessage struct {
 	Message
 	Event   string          `json:"event"`
 	RawData json.RawMessage `json:"data"`
+
+	// Ref is an optional client chosen id echoed on the reply to this message
+	Ref string `json:"ref,omitempty"`
+
+	// Since is sent with Subscribe to replay retained messages with a greater
+	// sequence number before live traffic
+	Since *uint64 `json:"since,omitempty"`
+
+	// Metadata carries out of band values such as a traceparent
+	Metadata map[string]string `json:"meta,omitempty"`
+
+	// codec the message was decoded with, nil for JSON
+	codec Codec
 }
 
 type SubscribeMessage = ClientMessage
 type UnsubscribeMessage = ClientMessage
 
+// Data decodes the message's payload with the codec it arrived in.
 func (cm *ClientMessage) Data(v interface{}) error {
+	if cm.codec != nil {
+		return cm.codec.Unmarshal(cm.RawData, v)
+	}
+
 	return json.Unmarshal(cm.RawData, v)
 }
 
@@ -34,6 +63,11 @@ type ServerMessage struct {
 	Message
 	Event string      `json:"event"`
 	Data  interface{} `json:"data"`
+	Seq   uint64      `json:"seq,omitempty"`
+	Ref   string      `json:"ref,omitempty"`
+
+	// Pattern is the pattern subscription this message was delivered for
+	Pattern string `json:"pattern,omitempty"`
 }
 
 func (sm *ServerMessage) Marshal() ([]byte, error) {
@@ -44,6 +78,7 @@ type ServerError struct {
 	Type ConnectionEvent `json:"type"`
 	Msg  string          `json:"error"`
 	Data interface{}     `json:"data"`
+	Ref  string          `json:"ref,omitempty"`
 }
 
 func (se *ServerError) Error() string {
@@ -56,6 +91,25 @@ func (se *ServerError) Marshal() ([]byte, error) {
 
 type ServerErrorFields = map[string]interface{}
 
+// ErrCodeMalformedMessage is sent when a client message cannot be decoded or
+// has an unknown type.
+const ErrCodeMalformedMessage = "malformed_message"
+
+// ErrCodeNotSubscribed is sent when a client sends an event to a channel it
+// has not joined.
+const ErrCodeNotSubscribed = "not_subscribed"
+
+// MalformedMessagePolicy decides what happens when a client sends a message
+// that cannot be decoded or has an unknown type.
+type MalformedMessagePolicy int
+
+const (
+	// CloseOnMalformed closes the connection with CloseInvalidFramePayloadData
+	CloseOnMalformed MalformedMessagePolicy = iota
+	// RejectMalformed answers with a ServerError and keeps the connection open
+	RejectMalformed
+)
+
 func NewServerError(error string, data ServerErrorFields) *ServerError {
 	return &ServerError{
 		Type: ServerErrorMessageType,
@@ -63,3 +117,26 @@ func NewServerError(error string, data ServerErrorFields) *ServerError {
 		Data: data,
 	}
 }
+
+const (
+	RejectUnauthorized = "unauthorized"
+	RejectForbidden    = "forbidden"
+	RejectJoinFailed   = "join_failed"
+
+	RejectInvalidPattern = "invalid_pattern"
+)
+
+// Rejection is returned from Authorize or BeforeJoin hooks to refuse a
+// subscription. Code is sent back to the client alongside the channel.
+type Rejection struct {
+	Code string
+	Msg  string
+}
+
+func (r *Rejection) Error() string {
+	return r.Msg
+}
+
+func Reject(code string, msg string) *Rejection {
+	return &Rejection{Code: code, Msg: msg}
+}
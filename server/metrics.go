@@ -0,0 +1,308 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type metricKind string
+
+const (
+	counterMetric   metricKind = "counter"
+	gaugeMetric     metricKind = "gauge"
+	histogramMetric metricKind = "histogram"
+)
+
+var handlerLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// metricVec is a metric family partitioned by labels. Counters and gauges are
+// updated atomically; histogram series take a lock per observation.
+type metricVec struct {
+	name    string
+	help    string
+	kind    metricKind
+	labels  []string
+	buckets []float64
+
+	// value reports metrics without labels that are counted elsewhere
+	value func() float64
+
+	mu     sync.RWMutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       int64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (v *metricVec) with(labelValues ...string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.RLock()
+	series, ok := v.series[key]
+	v.mu.RUnlock()
+
+	if ok {
+		return series
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if series, ok := v.series[key]; ok {
+		return series
+	}
+
+	series = &metricSeries{labelValues: labelValues}
+
+	if v.kind == histogramMetric {
+		series.counts = make([]uint64, len(v.buckets))
+	}
+
+	v.series[key] = series
+
+	return series
+}
+
+func (v *metricVec) add(n int64, labelValues ...string) {
+	atomic.AddInt64(&v.with(labelValues...).value, n)
+}
+
+func (v *metricVec) observe(value float64, labelValues ...string) {
+	series := v.with(labelValues...)
+
+	series.mu.Lock()
+	defer series.mu.Unlock()
+
+	for i, bound := range v.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+
+	series.sum += value
+	series.count++
+}
+
+// Writes the family in the Prometheus text exposition format.
+func (v *metricVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+
+	if v.value != nil {
+		fmt.Fprintf(w, "%s %s\n", v.name, formatFloat(v.value()))
+		return
+	}
+
+	v.mu.RLock()
+	series := make([]*metricSeries, 0, len(v.series))
+
+	for _, s := range v.series {
+		series = append(series, s)
+	}
+	v.mu.RUnlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		return strings.Join(series[i].labelValues, "\xff") < strings.Join(series[j].labelValues, "\xff")
+	})
+
+	for _, s := range series {
+		labels := formatLabels(v.labels, s.labelValues)
+
+		if v.kind != histogramMetric {
+			fmt.Fprintf(w, "%s%s %d\n", v.name, wrapLabels(labels), atomic.LoadInt64(&s.value))
+			continue
+		}
+
+		s.mu.Lock()
+
+		for i, bound := range v.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, wrapLabels(appendLabel(labels, "le", formatFloat(bound))), s.counts[i])
+		}
+
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, wrapLabels(appendLabel(labels, "le", "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, wrapLabels(labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, wrapLabels(labels), s.count)
+
+		s.mu.Unlock()
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string) string {
+	pairs := make([]string, len(names))
+
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func appendLabel(labels string, name string, value string) string {
+	label := fmt.Sprintf(`%s="%s"`, name, value)
+
+	if labels == "" {
+		return label
+	}
+
+	return labels + "," + label
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+
+	return "{" + labels + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// metrics are collected by every RealtimeServer. A nil *metrics, as used by
+// hubs and connections created outside a server, records nothing.
+type metrics struct {
+	connections     *metricVec
+	channels        *metricVec
+	subscribes      *metricVec
+	unsubscribes    *metricVec
+	received        *metricVec
+	sent            *metricVec
+	dropped         *metricVec
+	handlerDuration *metricVec
+	handlerErrors   *metricVec
+	rejected        *metricVec
+}
+
+func newMetrics(dropped func() float64) *metrics {
+	vec := func(name string, help string, kind metricKind, labels ...string) *metricVec {
+		return &metricVec{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*metricSeries)}
+	}
+
+	m := &metrics{
+		connections:     vec("realtime_connections_open", "Open websocket connections.", gaugeMetric),
+		channels:        vec("realtime_channels_open", "Open channels by factory path.", gaugeMetric, "path"),
+		subscribes:      vec("realtime_subscribes_total", "Subscriptions to channels by factory path.", counterMetric, "path"),
+		unsubscribes:    vec("realtime_unsubscribes_total", "Unsubscriptions from channels by factory path.", counterMetric, "path"),
+		received:        vec("realtime_messages_received_total", "Messages received from clients by factory path and event.", counterMetric, "path", "event"),
+		sent:            vec("realtime_messages_sent_total", "Messages queued for clients by event.", counterMetric, "event"),
+		dropped:         vec("realtime_messages_dropped_total", "Messages dropped because a send queue was full.", counterMetric),
+		handlerDuration: vec("realtime_handler_duration_seconds", "Event handler latency by factory path and handler.", histogramMetric, "path", "handler"),
+		handlerErrors:   vec("realtime_handler_errors_total", "Event handler errors by factory path and handler.", counterMetric, "path", "handler"),
+		rejected:        vec("realtime_rate_limited_total", "Messages rejected by rate limits by scope.", counterMetric, "scope"),
+	}
+
+	m.dropped.value = dropped
+	m.handlerDuration.buckets = handlerLatencyBuckets
+
+	return m
+}
+
+func (m *metrics) all() []*metricVec {
+	return []*metricVec{
+		m.connections,
+		m.channels,
+		m.subscribes,
+		m.unsubscribes,
+		m.received,
+		m.sent,
+		m.dropped,
+		m.handlerDuration,
+		m.handlerErrors,
+		m.rejected,
+	}
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	for _, vec := range m.all() {
+		vec.write(w)
+	}
+}
+
+func (m *metrics) connectionOpened() {
+	if m != nil {
+		m.connections.add(1)
+	}
+}
+
+func (m *metrics) connectionClosed() {
+	if m != nil {
+		m.connections.add(-1)
+	}
+}
+
+func (m *metrics) channelOpened(path string) {
+	if m != nil {
+		m.channels.add(1, path)
+	}
+}
+
+func (m *metrics) channelClosed(path string) {
+	if m != nil {
+		m.channels.add(-1, path)
+	}
+}
+
+func (m *metrics) subscribed(path string) {
+	if m != nil {
+		m.subscribes.add(1, path)
+	}
+}
+
+func (m *metrics) unsubscribed(path string) {
+	if m != nil {
+		m.unsubscribes.add(1, path)
+	}
+}
+
+func (m *metrics) messageReceived(path string, event string) {
+	if m != nil {
+		m.received.add(1, path, event)
+	}
+}
+
+func (m *metrics) messageSent(event string) {
+	if m != nil {
+		m.sent.add(1, event)
+	}
+}
+
+func (m *metrics) handled(path string, handler string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.handlerDuration.observe(duration.Seconds(), path, handler)
+
+	if err != nil {
+		m.handlerErrors.add(1, path, handler)
+	}
+}
+
+func (m *metrics) rateLimited(scope string) {
+	if m != nil {
+		m.rejected.add(1, scope)
+	}
+}
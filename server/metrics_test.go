@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func scrapeMetrics(rts *RealtimeServer) string {
+	rec := httptest.NewRecorder()
+	rts.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	return rec.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	rts := NewRealtimeServer()
+	cf := NewChannelFactory("room.{id}")
+
+	cf.HandleReply("ping", func(ctx context.Context, e *Event) (interface{}, error) {
+		return "pong", nil
+	})
+	cf.Handle("fail", func(ctx context.Context, e *Event) error {
+		return errors.New("failed")
+	})
+	rts.RegisterChannelFactory(cf)
+
+	ws := dialTestServer(t, rts)
+
+	// Event names on Subscribe are chosen by clients and must not become labels
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: Subscribe, Channel: "room.1"}, Event: "attacker-a", Ref: "1"})
+
+	// Wait for the subscription to be confirmed
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, event := range []string{"ping", "fail", "nope"} {
+		ws.WriteJSON(&ClientMessage{Message: Message{Type: ClientEvent, Channel: "room.1"}, Event: event, Ref: event})
+
+		if _, _, err := ws.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{
+		"# TYPE realtime_connections_open gauge",
+		"realtime_connections_open 1",
+		`realtime_channels_open{path="room.{id}"} 1`,
+		`realtime_subscribes_total{path="room.{id}"} 1`,
+		`realtime_messages_received_total{path="room.{id}",event="Subscribe"} 1`,
+		`realtime_messages_received_total{path="room.{id}",event="ping"} 1`,
+		`realtime_messages_received_total{path="room.{id}",event="unhandled"} 1`,
+		`realtime_messages_sent_total{event="ServerReply"} 1`,
+		`realtime_messages_sent_total{event="ping"} 1`,
+		`realtime_messages_sent_total{event="ServerError"} 2`,
+		"realtime_messages_dropped_total 0",
+		`realtime_handler_duration_seconds_bucket{path="room.{id}",handler="ping",le="+Inf"} 1`,
+		`realtime_handler_duration_seconds_count{path="room.{id}",handler="fail"} 1`,
+		`realtime_handler_errors_total{path="room.{id}",handler="fail"} 1`,
+	}
+
+	body := scrapeMetrics(rts)
+
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected metrics to contain %q", line)
+		}
+	}
+
+	if strings.Contains(body, "attacker-a") {
+		t.Error("metrics should not be labeled with client chosen event names")
+	}
+
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+
+	closed := []string{
+		"realtime_connections_open 0",
+		`realtime_channels_open{path="room.{id}"} 0`,
+		`realtime_unsubscribes_total{path="room.{id}"} 1`,
+	}
+
+	deadline := time.Now().Add(time.Second)
+
+	for _, line := range closed {
+		for !strings.Contains(scrapeMetrics(rts), line+"\n") {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected metrics to contain %q after close", line)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
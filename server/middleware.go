@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"runtime/debug"
+)
+
+// Middleware wraps a ChannelEventHandler with cross-cutting behaviour. It
+// applies to every handler, including the built-in join and leave hooks.
+type Middleware func(ChannelEventHandler) ChannelEventHandler
+
+// Wraps handler so the first middleware is the outermost.
+func chainMiddleware(handler ChannelEventHandler, middleware []Middleware) ChannelEventHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+// Recover turns a panicking handler into an error, which is sent back to the
+// client like any other handler error.
+func Recover() Middleware {
+	return func(next ChannelEventHandler) ChannelEventHandler {
+		return func(ctx context.Context, event *Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					event.Channel.log(LevelError, "handler panicked", connField(event.Conn), eventField(event.Name()), errField(r), Field{Key: "stack", Value: string(debug.Stack())})
+					err = NewServerError("Internal server error", ServerErrorFields{
+						"channel": event.Channel.Name,
+						"event":   event.Handler,
+					})
+				}
+			}()
+
+			return next(ctx, event)
+		}
+	}
+}
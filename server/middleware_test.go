@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestMiddlewareOrderAndRecover(t *testing.T) {
+	hub := newHub()
+	cf := NewChannelFactory("room")
+
+	var calls []string
+
+	trace := func(name string) Middleware {
+		return func(next ChannelEventHandler) ChannelEventHandler {
+			return func(ctx context.Context, e *Event) error {
+				calls = append(calls, name+":"+e.Handler)
+				return next(ctx, e)
+			}
+		}
+	}
+
+	hub.use(trace("server"))
+	cf.Use(Recover(), trace("factory"))
+	cf.Join(func(ctx context.Context, e *Event) error { return nil })
+	cf.Handle("boom", func(ctx context.Context, e *Event) error {
+		panic("boom")
+	})
+	hub.registerChannelFactory(cf)
+
+	channel, _ := hub.findOrOpenChannel("room")
+	conn := newTestConnection()
+
+	channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+		Message: Message{Type: Subscribe, Channel: "room"},
+	}))
+
+	channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+		Message: Message{Type: ClientEvent, Channel: "room"},
+		Event:   "boom",
+	}))
+
+	expected := []string{"server:" + Join, "factory:" + Join, "server:boom", "factory:boom"}
+
+	if len(calls) != len(expected) {
+		t.Fatalf("expected calls %v, got %v", expected, calls)
+	}
+
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("expected calls %v, got %v", expected, calls)
+			break
+		}
+	}
+
+	var serverErr ServerError
+	json.Unmarshal(nextMessage(t, conn), &serverErr)
+
+	if serverErr.Type != ServerErrorMessageType {
+		t.Errorf("panic should be reported as a ServerError, got %#v", serverErr)
+	}
+}
+
+func TestPanicWithoutRecoverEchoesRef(t *testing.T) {
+	rts := NewRealtimeServer()
+	cf := NewChannelFactory("room")
+	cf.Handle("boom", func(ctx context.Context, e *Event) error {
+		panic("boom")
+	})
+	rts.RegisterChannelFactory(cf)
+
+	ws := dialTestServer(t, rts)
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: Subscribe, Channel: "room"}, Ref: "1"})
+
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: ClientEvent, Channel: "room"}, Event: "boom", Ref: "2"})
+
+	var serverErr ServerError
+
+	if err := ws.ReadJSON(&serverErr); err != nil {
+		t.Fatal(err)
+	}
+
+	if serverErr.Type != ServerErrorMessageType || serverErr.Ref != "2" {
+		t.Errorf("expected internal error with ref, got %#v", serverErr)
+	}
+}
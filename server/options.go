@@ -0,0 +1,270 @@
+package server
+
+import (
+	"compress/flate"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultWriteWait = 10 * time.Second
+	defaultPongWait  = 60 * time.Second
+)
+
+// DefaultReadLimit is the largest message in bytes a client may send unless
+// set with WithReadLimit.
+const DefaultReadLimit = 1 << 20
+
+// Option configures a RealtimeServer created with NewRealtimeServer.
+type Option func(*RealtimeServer)
+
+// WithCheckOrigin decides whether the Origin of an upgrade request is
+// allowed. By default every origin is.
+func WithCheckOrigin(check func(r *http.Request) bool) Option {
+	if check == nil {
+		panic("rts: nil origin check")
+	}
+
+	return func(s *RealtimeServer) {
+		s.upgrader.CheckOrigin = check
+	}
+}
+
+// WithAllowedOrigins only upgrades requests whose Origin is one of origins,
+// such as https://example.com, or whose Origin is the request's own host.
+// Requests without an Origin, which browsers always send, are allowed.
+func WithAllowedOrigins(origins ...string) Option {
+	allowed := make(map[string]bool, len(origins))
+
+	for _, origin := range origins {
+		allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
+	return WithCheckOrigin(func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+
+		if origin == "" || allowed[strings.ToLower(origin)] {
+			return true
+		}
+
+		u, err := url.Parse(origin)
+
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	})
+}
+
+// WithBufferSizes sets the size in bytes of each connection's read and write
+// buffers. Zero uses the websocket package's default of 4096.
+func WithBufferSizes(read, write int) Option {
+	return func(s *RealtimeServer) {
+		s.upgrader.ReadBufferSize = read
+		s.upgrader.WriteBufferSize = write
+	}
+}
+
+// WithReadLimit sets the largest message in bytes a client may send.
+// Connections sending larger messages are closed with CloseMessageTooBig.
+// Zero means no limit.
+func WithReadLimit(limit int64) Option {
+	return func(s *RealtimeServer) {
+		s.readLimit = limit
+	}
+}
+
+// WithMalformedMessagePolicy sets what happens when a client sends a message
+// that cannot be decoded or has an unknown type. Defaults to
+// CloseOnMalformed.
+func WithMalformedMessagePolicy(policy MalformedMessagePolicy) Option {
+	return func(s *RealtimeServer) {
+		s.malformed = policy
+	}
+}
+
+// WithRateLimit limits the messages each connection may send. Messages over
+// the limit are answered with a ServerError. Channel factories can set
+// further limits with LimitRate and LimitEventRate.
+func WithRateLimit(limit RateLimit) Option {
+	if limit.Rate < 0 || limit.Burst < 0 {
+		panic("rts: invalid rate limit")
+	}
+
+	return func(s *RealtimeServer) {
+		s.rateLimit = limit
+	}
+}
+
+// WithRateLimitDisconnect closes connections with ClosePolicyViolation once
+// they exceed any rate limit policy.Violations times within policy.Window.
+func WithRateLimitDisconnect(policy RateLimitDisconnect) Option {
+	if policy.Violations > 0 && policy.Window <= 0 {
+		panic("rts: invalid rate limit disconnect window")
+	}
+
+	return func(s *RealtimeServer) {
+		s.rateLimitDisconnect = policy
+	}
+}
+
+// WithHistoryLimit sets how many closed channels may keep recording and
+// retaining history on this node for replay. The longest closed are dropped
+// first. Defaults to DefaultHistoryLimit.
+func WithHistoryLimit(limit int) Option {
+	if limit < 0 {
+		panic("rts: history limit must not be negative")
+	}
+
+	return func(s *RealtimeServer) {
+		s.Hub.historyLimit = limit
+	}
+}
+
+// WithKeepalive sets how often connections are pinged and how long a client
+// has to answer before it is disconnected. pingPeriod must be shorter than
+// pongWait. Defaults to 54s and 60s.
+func WithKeepalive(pingPeriod, pongWait time.Duration) Option {
+	if pingPeriod <= 0 || pingPeriod >= pongWait {
+		panic("rts: ping period must be positive and shorter than pong wait")
+	}
+
+	return func(s *RealtimeServer) {
+		s.pingPeriod = pingPeriod
+		s.pongWait = pongWait
+	}
+}
+
+// WithWriteWait sets how long a write to a client may take. Defaults to 10s.
+func WithWriteWait(writeWait time.Duration) Option {
+	if writeWait <= 0 {
+		panic("rts: write wait must be positive")
+	}
+
+	return func(s *RealtimeServer) {
+		s.writeWait = writeWait
+	}
+}
+
+// WithHandshakeTimeout limits how long the websocket handshake may take. Zero
+// means no limit.
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(s *RealtimeServer) {
+		s.upgrader.HandshakeTimeout = timeout
+	}
+}
+
+// WithCodecs sets the codecs, and so the subprotocols, clients may
+// negotiate, in order of preference. Clients that request none of them use
+// the first. Defaults to JSON and MessagePack.
+func WithCodecs(codecs ...Codec) Option {
+	if len(codecs) == 0 {
+		panic("rts: WithCodecs requires at least one codec")
+	}
+
+	subprotocols := make([]string, len(codecs))
+
+	for i, codec := range codecs {
+		subprotocols[i] = codec.Name()
+	}
+
+	return func(s *RealtimeServer) {
+		s.codecs = codecs
+		s.upgrader.Subprotocols = subprotocols
+	}
+}
+
+// WithCompression sets how outbound messages are compressed. Defaults to
+// DefaultCompressionConfig, which does not compress.
+func WithCompression(config CompressionConfig) Option {
+	if config.Level < flate.HuffmanOnly || config.Level > flate.BestCompression {
+		panic(fmt.Sprintf("rts: invalid compression level %d", config.Level))
+	}
+
+	if config.Level == flate.NoCompression {
+		config.Level = flate.DefaultCompression
+	}
+
+	return func(s *RealtimeServer) {
+		s.compression = config
+		s.upgrader.EnableCompression = config.Enabled
+	}
+}
+
+// WithSendQueue sets the size and BackpressurePolicy of each connection's
+// send queue. Defaults to DefaultSendQueueConfig.
+func WithSendQueue(config SendQueueConfig) Option {
+	return func(s *RealtimeServer) {
+		s.sendQueue = config
+	}
+}
+
+// WithAuthenticator resolves the identity of every request before it is
+// upgraded. Requests it rejects never reach the hub.
+func WithAuthenticator(a Authenticator) Option {
+	return func(s *RealtimeServer) {
+		s.authenticator = a
+	}
+}
+
+// WithBroker sets the Broker channels publish through. Defaults to a
+// MemoryBroker; use a shared broker such as a NetBroker to fan out across
+// nodes.
+func WithBroker(b Broker) Option {
+	if b == nil {
+		panic("rts: nil broker")
+	}
+
+	return func(s *RealtimeServer) {
+		s.Hub.broker = b
+	}
+}
+
+// WithPatternAuthorizer allows clients to Subscribe to patterns such as
+// orders.*.status, receiving events from every matching channel. Pattern
+// subscriptions bypass each channel's Authorize and BeforeJoin hooks, so they
+// are refused unless an authorizer is set.
+func WithPatternAuthorizer(authorize PatternAuthorizer) Option {
+	return func(s *RealtimeServer) {
+		s.patternAuthorizer = authorize
+	}
+}
+
+// WithLogger sends the server's log entries to logger. Defaults to info and
+// above written to the standard logger; use NopLogger to silence the server.
+func WithLogger(logger Logger) Option {
+	if logger == nil {
+		panic("rts: nil logger")
+	}
+
+	return func(s *RealtimeServer) {
+		s.logger = logger
+	}
+}
+
+// WithTracer starts a span for every Subscribe and ClientEvent, with child
+// spans for the hub lookup and each hook and handler. Handlers receive their
+// span in their context. Spans are not recorded by default.
+func WithTracer(tracer Tracer) Option {
+	if tracer == nil {
+		panic("rts: nil tracer")
+	}
+
+	return func(s *RealtimeServer) {
+		s.tracer = tracer
+	}
+}
+
+// WithIDGenerator generates the ids of new connections. Defaults to random
+// UUIDs.
+func WithIDGenerator(newID func() uuid.UUID) Option {
+	if newID == nil {
+		panic("rts: nil id generator")
+	}
+
+	return func(s *RealtimeServer) {
+		s.newID = newID
+	}
+}
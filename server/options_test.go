@@ -0,0 +1,138 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+func TestWithAllowedOrigins(t *testing.T) {
+	rts := NewRealtimeServer(WithAllowedOrigins("https://example.com"))
+
+	srv := httptest.NewServer(rts)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"https://example.com", true},
+		{"https://EXAMPLE.com", true},
+		{srv.URL, true},
+		{"https://evil.com", false},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+
+		ws, resp, err := websocket.DefaultDialer.Dial(url, header)
+
+		if tt.allowed && err != nil {
+			t.Errorf("origin %q should be allowed, got %v", tt.origin, err)
+		}
+
+		if !tt.allowed && (err == nil || resp.StatusCode != http.StatusForbidden) {
+			t.Errorf("origin %q should be forbidden", tt.origin)
+		}
+
+		if ws != nil {
+			ws.Close()
+		}
+	}
+}
+
+func TestWithIDGenerator(t *testing.T) {
+	id := uuid.MustParse("00000000-0000-0000-0000-000000000001")
+	rts := NewRealtimeServer(WithIDGenerator(func() uuid.UUID { return id }))
+
+	srv := httptest.NewServer(rts)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ws.Close()
+
+	deadline := time.Now().Add(time.Second)
+
+	for time.Now().Before(deadline) {
+		rts.connsMu.Lock()
+
+		for conn := range rts.connections {
+			if conn.Id == id {
+				rts.connsMu.Unlock()
+				return
+			}
+		}
+
+		rts.connsMu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Error("connection should use the generated id")
+}
+
+func TestWithKeepaliveRejectsInvalidPeriod(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected ping period longer than pong wait to panic")
+		}
+	}()
+
+	WithKeepalive(time.Minute, time.Second)
+}
+
+func TestOptionsConfigureUpgrader(t *testing.T) {
+	rts := NewRealtimeServer(
+		WithBufferSizes(1024, 2048),
+		WithHandshakeTimeout(time.Second),
+		WithCodecs(MsgpackCodec),
+		WithCompression(CompressionConfig{Enabled: true}),
+	)
+
+	if rts.upgrader.ReadBufferSize != 1024 || rts.upgrader.WriteBufferSize != 2048 {
+		t.Errorf("unexpected buffer sizes %d, %d", rts.upgrader.ReadBufferSize, rts.upgrader.WriteBufferSize)
+	}
+
+	if rts.upgrader.HandshakeTimeout != time.Second {
+		t.Errorf("unexpected handshake timeout %s", rts.upgrader.HandshakeTimeout)
+	}
+
+	if len(rts.upgrader.Subprotocols) != 1 || rts.codec("") != MsgpackCodec {
+		t.Errorf("expected msgpack to be the only codec, got %v", rts.upgrader.Subprotocols)
+	}
+
+	if !rts.upgrader.EnableCompression {
+		t.Error("expected compression to be negotiated")
+	}
+}
+
+func TestPatternsRefusedWithoutAuthorizer(t *testing.T) {
+	if err := NewRealtimeServer().authorizePattern(nil, "orders.*"); err == nil {
+		t.Error("patterns should be refused without an authorizer")
+	}
+
+	rts := NewRealtimeServer(WithPatternAuthorizer(func(conn *Connection, pattern string) error {
+		return nil
+	}))
+
+	if err := rts.authorizePattern(nil, "orders.*"); err != nil {
+		t.Errorf("authorized pattern should be allowed, got %v", err)
+	}
+}
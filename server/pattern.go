@@ -0,0 +1,149 @@
+package server
+
+import "strings"
+
+// PatternAuthorizer decides whether conn may subscribe to every channel
+// matching pattern. Returning an error, typically a *Rejection, refuses it.
+type PatternAuthorizer func(conn *Connection, pattern string) error
+
+type patternSubscription struct {
+	pattern     string
+	path        *DotPath
+	connections ConnectionMap
+}
+
+// Whether a Subscribe names a pattern of channels rather than a channel.
+func isPattern(name string) bool {
+	return strings.Contains(name, WildcardParam)
+}
+
+// Subscribes conn to every channel matching pattern. While any pattern
+// subscription exists the hub receives every message from the broker.
+func (h *Hub) subscribePattern(conn *Connection, pattern string) error {
+	path, err := ParseDotPath(pattern)
+
+	if err != nil {
+		return Reject(RejectInvalidPattern, err.Error())
+	}
+
+	h.patternsMu.Lock()
+	defer h.patternsMu.Unlock()
+
+	// A closed connection has already been removed from every pattern
+	if conn.hasLeft() {
+		return errConnectionClosed
+	}
+
+	if len(h.patterns) == 0 {
+		unsubscribe, err := h.broker.SubscribeAll(h.deliverPattern)
+
+		if err != nil {
+			return err
+		}
+
+		h.unsubscribeAll = unsubscribe
+	}
+
+	sub, ok := h.patterns[pattern]
+
+	if !ok {
+		sub = &patternSubscription{
+			pattern:     pattern,
+			path:        path,
+			connections: make(ConnectionMap),
+		}
+		h.patterns[pattern] = sub
+	}
+
+	h.logger.Log(LevelDebug, "pattern subscribed", connField(conn), Field{Key: "pattern", Value: pattern})
+	sub.connections[conn] = true
+
+	return nil
+}
+
+func (h *Hub) unsubscribePattern(conn *Connection, pattern string) {
+	h.patternsMu.Lock()
+	defer h.patternsMu.Unlock()
+
+	h.removePatternConnection(conn, pattern)
+}
+
+// Removes conn from every pattern it subscribed to.
+func (h *Hub) unsubscribePatterns(conn *Connection) {
+	h.patternsMu.Lock()
+	defer h.patternsMu.Unlock()
+
+	for pattern := range h.patterns {
+		h.removePatternConnection(conn, pattern)
+	}
+}
+
+// Callers must hold h.patternsMu.
+func (h *Hub) removePatternConnection(conn *Connection, pattern string) {
+	sub, ok := h.patterns[pattern]
+
+	if !ok {
+		return
+	}
+
+	delete(sub.connections, conn)
+
+	if len(sub.connections) > 0 {
+		return
+	}
+
+	delete(h.patterns, pattern)
+
+	if len(h.patterns) == 0 && h.unsubscribeAll != nil {
+		h.unsubscribeAll()
+		h.unsubscribeAll = nil
+	}
+}
+
+// Delivers a broker message to the pattern subscribers of its channel,
+// skipping connections subscribed to the channel itself so they do not
+// receive it twice.
+func (h *Hub) deliverPattern(msg *BrokerMessage) {
+	h.patternsMu.RLock()
+	defer h.patternsMu.RUnlock()
+
+	if len(h.patterns) == 0 {
+		return
+	}
+
+	channel, _ := h.findChannel(msg.Channel)
+	cf, _, _ := h.findChannelFactory(msg.Channel)
+
+	for _, sub := range h.patterns {
+		if matches, _ := sub.path.doesMatch(msg.Channel); !matches {
+			continue
+		}
+
+		serverMessage := &ServerMessage{
+			Message: Message{
+				Channel: msg.Channel,
+				Type:    ServerEvent,
+			},
+			Event:   msg.Event,
+			Data:    msg.payload(),
+			Pattern: sub.pattern,
+		}
+
+		prepared := prepare(serverMessage)
+		prepared.uncompressed = cf != nil && cf.uncompressed
+
+		for conn := range sub.connections {
+			if msg.Exclude != "" && conn.Id.String() == msg.Exclude {
+				continue
+			}
+
+			if channel != nil && channel.hasConnection(conn) {
+				continue
+			}
+
+			if err := conn.sendPrepared(prepared); err != nil {
+				h.logger.Log(LevelError, "marshalling message failed", connField(conn), channelField(msg.Channel), eventField(msg.Event), errField(err))
+			}
+		}
+	}
+}
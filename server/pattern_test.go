@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestPatternSubscription(t *testing.T) {
+	hub := newHub()
+	hub.registerChannelFactory(NewChannelFactory("orders.{id}.status"))
+
+	watcher := newTestConnection()
+
+	if err := hub.subscribePattern(watcher, "orders.*.status"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Channels need not be open on this node for pattern subscribers
+	hub.publish("orders.1.status", "shipped", nil)
+
+	var msg ServerMessage
+	json.Unmarshal(nextMessage(t, watcher), &msg)
+
+	if msg.Channel != "orders.1.status" || msg.Pattern != "orders.*.status" || msg.Event != "shipped" {
+		t.Errorf("unexpected message %#v", msg)
+	}
+
+	// Connections subscribed directly are not sent the message twice
+	channel, _ := hub.findOrOpenChannel("orders.2.status")
+	channel.handleEvent(context.Background(), NewEvent(channel, watcher, &ClientMessage{
+		Message: Message{Type: Subscribe, Channel: "orders.2.status"},
+	}))
+
+	hub.publish("orders.2.status", "shipped", nil)
+	nextMessage(t, watcher)
+
+	if _, ok := watcher.send.pop(); ok {
+		t.Error("direct subscriber should receive the message once")
+	}
+
+	hub.unsubscribePatterns(watcher)
+	hub.publish("orders.3.status", "shipped", nil)
+
+	if _, ok := watcher.send.pop(); ok {
+		t.Error("unsubscribed connection should not receive pattern messages")
+	}
+
+	if hub.unsubscribeAll != nil {
+		t.Error("hub should stop receiving every message without pattern subscriptions")
+	}
+}
+
+func TestPatternSubscriptionInvalid(t *testing.T) {
+	hub := newHub()
+
+	if err := hub.subscribePattern(newTestConnection(), "orders.**.status"); err == nil {
+		t.Error("invalid pattern should be rejected")
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// preparedMessage is a message fanned out to many connections. It is encoded
+// at most once per codec, however many connections it is sent to.
+type preparedMessage struct {
+	value interface{}
+	event string
+
+	// uncompressed opts the message out of compression
+	uncompressed bool
+
+	mu      sync.Mutex
+	encoded map[Codec]*encodedMessage
+}
+
+// encodedMessage is a message encoded with a single codec. Its websocket
+// frames, compressed or not, are built by the first connection writing it and
+// shared with every other connection it is queued on.
+type encodedMessage struct {
+	data         []byte
+	prepared     *websocket.PreparedMessage
+	uncompressed bool
+
+	// event labels the message in metrics
+	event string
+}
+
+func prepare(v interface{}) *preparedMessage {
+	return &preparedMessage{value: v, event: messageEvent(v)}
+}
+
+// Names the event of an outbound message for metrics.
+func messageEvent(v interface{}) string {
+	switch msg := v.(type) {
+	case *ServerMessage:
+		if msg.Event != "" {
+			return msg.Event
+		}
+
+		return string(msg.Type)
+	case *ServerError:
+		return string(msg.Type)
+	}
+
+	return ""
+}
+
+func (p *preparedMessage) encode(codec Codec) (*encodedMessage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if encoded, ok := p.encoded[codec]; ok {
+		return encoded, nil
+	}
+
+	data, err := codec.Marshal(p.value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	prepared, err := websocket.NewPreparedMessage(codec.FrameType(), data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.encoded == nil {
+		p.encoded = make(map[Codec]*encodedMessage)
+	}
+
+	encoded := &encodedMessage{data: data, prepared: prepared, uncompressed: p.uncompressed, event: p.event}
+	p.encoded[codec] = encoded
+
+	return encoded, nil
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBroadcastEncodesOncePerCodec(t *testing.T) {
+	hub := newHub()
+	hub.registerChannelFactory(NewChannelFactory("room"))
+	channel, _ := hub.findOrOpenChannel("room")
+
+	conns := []*Connection{newTestConnection(), newTestConnection(), newTestConnection()}
+	conns[2].codec = MsgpackCodec
+
+	for _, conn := range conns {
+		msg := &ClientMessage{Message: Message{Type: Subscribe, Channel: "room"}}
+		channel.handleEvent(context.Background(), NewEvent(channel, conn, msg))
+	}
+
+	channel.broadcastMessage(channel.newServerMessage("hello", "world"), "")
+
+	var sent []*encodedMessage
+
+	for _, conn := range conns {
+		msg, ok := conn.send.pop()
+
+		if !ok {
+			t.Fatalf("%s should be sent the broadcast", conn)
+		}
+
+		sent = append(sent, msg)
+	}
+
+	if sent[0] != sent[1] {
+		t.Error("connections sharing a codec should share the encoded message")
+	}
+
+	if sent[0] == sent[2] {
+		t.Error("connections with different codecs should not share the encoded message")
+	}
+}
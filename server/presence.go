@@ -0,0 +1,128 @@
+package server
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	PresenceDiff  = "presence_diff"
+	PresenceState = "presence_state"
+)
+
+// PresenceEntry is a single identity present in a channel. Metas holds the
+// metadata published by each of the identity's connections, decoded from
+// whichever codec each connection uses.
+type PresenceEntry struct {
+	Key   string        `json:"key"`
+	Metas []interface{} `json:"metas"`
+}
+
+type PresenceDiffData struct {
+	Joins  []PresenceEntry `json:"joins"`
+	Leaves []PresenceEntry `json:"leaves"`
+}
+
+type presence struct {
+	mu      sync.RWMutex
+	entries map[string]map[*Connection]interface{}
+}
+
+func newPresence() *presence {
+	return &presence{
+		entries: make(map[string]map[*Connection]interface{}),
+	}
+}
+
+// PresenceKeyer is implemented by identities that share a presence entry
+// across connections. The key is sent to every member of the channel, so it
+// should not reveal anything the identity's other fields hold.
+type PresenceKeyer interface {
+	PresenceKey() string
+}
+
+// Connections whose identities share a presence key collapse into a single
+// presence entry. Other connections are keyed by their connection id.
+func presenceKey(conn *Connection) string {
+	if keyer, ok := conn.Identity.(PresenceKeyer); ok {
+		return keyer.PresenceKey()
+	}
+
+	return conn.Id.String()
+}
+
+// Tracks conn under its presence key. Returns the entry and whether the key
+// was newly added to the channel.
+func (p *presence) track(conn *Connection, meta interface{}) (PresenceEntry, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := presenceKey(conn)
+	conns, exists := p.entries[key]
+
+	if !exists {
+		conns = make(map[*Connection]interface{})
+		p.entries[key] = conns
+	}
+
+	conns[conn] = meta
+
+	return p.entry(key), !exists
+}
+
+// Untracks conn. Returns the entry as it was before removal and whether the
+// key has left the channel entirely.
+func (p *presence) untrack(conn *Connection) (PresenceEntry, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := presenceKey(conn)
+	conns, exists := p.entries[key]
+
+	if !exists {
+		return PresenceEntry{}, false
+	}
+
+	if _, ok := conns[conn]; !ok {
+		return PresenceEntry{}, false
+	}
+
+	entry := p.entry(key)
+	delete(conns, conn)
+
+	if len(conns) > 0 {
+		return entry, false
+	}
+
+	delete(p.entries, key)
+
+	return entry, true
+}
+
+func (p *presence) entry(key string) PresenceEntry {
+	conns := p.entries[key]
+	metas := make([]interface{}, 0, len(conns))
+
+	for _, meta := range conns {
+		metas = append(metas, meta)
+	}
+
+	return PresenceEntry{Key: key, Metas: metas}
+}
+
+func (p *presence) list() []PresenceEntry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	entries := make([]PresenceEntry, 0, len(p.entries))
+
+	for key := range p.entries {
+		entries = append(entries, p.entry(key))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	return entries
+}
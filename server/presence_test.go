@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+type testUser struct {
+	id    string
+	email string
+}
+
+func (u *testUser) PresenceKey() string {
+	return u.id
+}
+
+func TestPresenceCollapsesIdentity(t *testing.T) {
+	p := newPresence()
+
+	first := &Connection{Id: uuid.New(), Identity: &testUser{id: "user-1"}}
+	second := &Connection{Id: uuid.New(), Identity: &testUser{id: "user-1"}}
+
+	if _, joined := p.track(first, map[string]interface{}{"status": "online"}); !joined {
+		t.Error("first connection should join presence")
+	}
+
+	if _, joined := p.track(second, map[string]interface{}{"status": "away"}); joined {
+		t.Error("second connection for same identity should not join again")
+	}
+
+	list := p.list()
+
+	if len(list) != 1 || len(list[0].Metas) != 2 {
+		t.Fatalf("expected one entry with two metas, got %v", list)
+	}
+
+	if _, left := p.untrack(first); left {
+		t.Error("identity should remain while a connection is present")
+	}
+
+	if _, left := p.untrack(second); !left {
+		t.Error("identity should leave with its last connection")
+	}
+
+	if len(p.list()) != 0 {
+		t.Error("presence should be empty")
+	}
+}
+
+func TestPresenceKeyDoesNotExposeIdentity(t *testing.T) {
+	p := newPresence()
+
+	keyed := &Connection{Id: uuid.New(), Identity: &testUser{id: "user-1", email: "alice@example.com"}}
+	unkeyed := &Connection{Id: uuid.New(), Identity: struct{ Email string }{"bob@example.com"}}
+
+	if key := presenceKey(keyed); key != "user-1" {
+		t.Errorf("expected the identity's presence key, got %q", key)
+	}
+
+	if key := presenceKey(unkeyed); key != unkeyed.Id.String() {
+		t.Errorf("identities without a presence key should use the connection id, got %q", key)
+	}
+
+	p.track(keyed, nil)
+	p.track(unkeyed, nil)
+
+	for _, entry := range p.list() {
+		if strings.Contains(entry.Key, "@") {
+			t.Errorf("presence key exposes identity: %q", entry.Key)
+		}
+	}
+}
+
+func TestPresenceStateRequiresMembership(t *testing.T) {
+	hub := newHub()
+	cf := NewChannelFactory("private.{id}")
+	cf.TrackPresence()
+	hub.registerChannelFactory(cf)
+
+	channel, _ := hub.findOrOpenChannel("private.1")
+	member := newTestConnection()
+	outsider := newTestConnection()
+
+	channel.handleEvent(context.Background(), NewEvent(channel, member, &ClientMessage{
+		Message: Message{Type: Subscribe, Channel: "private.1"},
+		RawData: json.RawMessage(`{"status":"online"}`),
+	}))
+
+	channel.handleEvent(context.Background(), NewEvent(channel, outsider, &ClientMessage{
+		Message: Message{Type: ClientEvent, Channel: "private.1"},
+		Event:   PresenceState,
+		Ref:     "1",
+	}))
+
+	var serverErr ServerError
+	json.Unmarshal(nextMessage(t, outsider), &serverErr)
+
+	if fields, _ := serverErr.Data.(map[string]interface{}); fields["code"] != ErrCodeNotSubscribed || serverErr.Ref != "1" {
+		t.Errorf("expected not subscribed error, got %#v", serverErr)
+	}
+
+	if outsider.send.len() != 0 {
+		t.Error("non-members should not receive the presence list")
+	}
+}
+
+func TestPresenceAcrossCodecs(t *testing.T) {
+	rts := NewRealtimeServer()
+	cf := NewChannelFactory("room")
+	cf.TrackPresence()
+	rts.RegisterChannelFactory(cf)
+
+	jsonClient := dialTestServer(t, rts)
+	jsonClient.WriteJSON(map[string]interface{}{
+		"type": Subscribe, "channel": "room", "ref": "1", "data": map[string]string{"status": "online"},
+	})
+
+	srv := httptest.NewServer(rts)
+	defer srv.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"msgpack"}}
+	msgpackClient, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer msgpackClient.Close()
+
+	// Waits for the JSON client to join so the msgpack client sees it
+	for i := 0; i < 2; i++ {
+		if _, _, err := jsonClient.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeMsgpack(t, msgpackClient, map[string]interface{}{
+		"type": Subscribe, "channel": "room", "ref": "1", "data": map[string]string{"status": "away"},
+	})
+
+	var state struct {
+		Event string          `json:"event"`
+		Data  []PresenceEntry `json:"data"`
+	}
+	readMsgpack(t, msgpackClient, &state)
+
+	if len(state.Data) != 2 {
+		t.Fatalf("expected both connections in presence_state, got %+v", state)
+	}
+
+	for _, entry := range state.Data {
+		if _, ok := entry.Metas[0].(map[string]interface{}); !ok {
+			t.Errorf("msgpack client should receive metas as maps, got %#v", entry.Metas[0])
+		}
+	}
+
+	jsonClient.SetReadDeadline(time.Now().Add(time.Second))
+
+	var diff struct {
+		Event string           `json:"event"`
+		Data  PresenceDiffData `json:"data"`
+	}
+
+	if err := jsonClient.ReadJSON(&diff); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.Event != PresenceDiff || len(diff.Data.Joins) != 1 {
+		t.Fatalf("expected a presence_diff with the msgpack join, got %+v", diff)
+	}
+
+	if meta, _ := diff.Data.Joins[0].Metas[0].(map[string]interface{}); meta["status"] != "away" {
+		t.Errorf("expected the msgpack client's metadata, got %#v", diff.Data.Joins[0].Metas)
+	}
+}
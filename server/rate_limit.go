@@ -0,0 +1,185 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrCodeRateLimited is sent when a client exceeds a rate limit. The error's
+// retry_after_ms field is how long until its next message would be allowed.
+const ErrCodeRateLimited = "rate_limited"
+
+const rateLimitReason = "rate limit exceeded"
+
+// RateLimit allows Burst messages at once, refilled at Rate messages per
+// second. A zero Rate means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0
+}
+
+// Rate limits are enforced for each connection on its own, whether they
+// apply to the connection, a channel or an event.
+const (
+	connectionLimit = "connection"
+	channelLimit    = "channel"
+	eventLimit      = "event"
+)
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// Takes a token, or reports how long until one is available.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	b.last = now
+
+	if burst := float64(b.limit.Burst); b.tokens > burst {
+		b.tokens = burst
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// rateLimiter holds a connection's token buckets and counts its recent
+// violations.
+type rateLimiter struct {
+	mu         sync.Mutex
+	buckets    map[string]*tokenBucket
+	violations []time.Time
+}
+
+// Takes a token from the bucket for key, creating it with limit.
+func (r *rateLimiter) allow(key string, limit RateLimit, now time.Time) (bool, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bucket, ok := r.buckets[key]
+
+	if !ok {
+		if r.buckets == nil {
+			r.buckets = make(map[string]*tokenBucket)
+		}
+
+		bucket = newTokenBucket(limit, now)
+		r.buckets[key] = bucket
+	}
+
+	return bucket.take(now)
+}
+
+// Records a violation, returning how many happened within window.
+func (r *rateLimiter) violate(window time.Duration, now time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recent := r.violations[:0]
+
+	for _, at := range r.violations {
+		if now.Sub(at) < window {
+			recent = append(recent, at)
+		}
+	}
+
+	r.violations = append(recent, now)
+
+	return len(r.violations)
+}
+
+// Forgets the buckets of a channel the connection left.
+func (r *rateLimiter) forget(channel string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.buckets {
+		if key == channel || strings.HasPrefix(key, channel+"\xff") {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// RateLimitDisconnect disconnects connections that exceed rate limits
+// Violations times within Window. A zero Violations never disconnects.
+type RateLimitDisconnect struct {
+	Violations int
+	Window     time.Duration
+}
+
+// Allows msg under the limit for scope, keyed within the connection by key.
+// Messages over the limit are answered with a ServerError and count towards
+// the server's RateLimitDisconnect policy.
+func (c *Connection) allowMessage(msg *ClientMessage, scope string, key string, limit RateLimit) bool {
+	if !limit.enabled() {
+		return true
+	}
+
+	now := time.Now()
+	ok, retryAfter := c.limiter.allow(key, limit, now)
+
+	if ok {
+		return true
+	}
+
+	c.log(LevelWarn, "rate limited", Field{Key: "scope", Value: scope}, channelField(msg.Channel), eventField(msg.Event))
+
+	if c.server != nil {
+		c.server.metrics.rateLimited(scope)
+	}
+
+	serverErr := NewServerError("Rate limit exceeded", ServerErrorFields{
+		"channel":        msg.Channel,
+		"event":          msg.Event,
+		"code":           ErrCodeRateLimited,
+		"scope":          scope,
+		"retry_after_ms": retryAfter.Milliseconds() + 1,
+	})
+	serverErr.Ref = msg.Ref
+	c.handleError(serverErr)
+
+	if c.server == nil || c.server.rateLimitDisconnect.Violations <= 0 {
+		return false
+	}
+
+	policy := c.server.rateLimitDisconnect
+
+	if c.limiter.violate(policy.Window, now) >= policy.Violations {
+		c.log(LevelWarn, "disconnecting rate limit abuser")
+		c.shutdown(websocket.ClosePolicyViolation, rateLimitReason)
+	}
+
+	return false
+}
+
+// Allows a ClientEvent under its channel's and then its event's rate limit.
+func (c *Channel) allowEvent(event *Event) bool {
+	if !event.Conn.allowMessage(event.Msg, channelLimit, c.Name, c.factory.rateLimit) {
+		return false
+	}
+
+	limit, ok := c.factory.eventLimits[event.Name()]
+
+	return !ok || event.Conn.allowMessage(event.Msg, eventLimit, c.Name+"\xff"+event.Name(), limit)
+}
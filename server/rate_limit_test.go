@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(RateLimit{Rate: 2, Burst: 2}, now)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := bucket.take(now); !ok {
+			t.Fatalf("burst token %d should be allowed", i)
+		}
+	}
+
+	ok, retryAfter := bucket.take(now)
+
+	if ok || retryAfter != 500*time.Millisecond {
+		t.Errorf("expected rejection with 500ms retry after, got %v %v", ok, retryAfter)
+	}
+
+	if ok, _ := bucket.take(now.Add(500 * time.Millisecond)); !ok {
+		t.Error("token should refill after retry after")
+	}
+
+	if ok, _ := bucket.take(now.Add(time.Hour)); !ok {
+		t.Error("bucket should refill")
+	}
+
+	if ok, _ := bucket.take(now.Add(time.Hour)); !ok {
+		t.Error("bucket should refill up to its burst")
+	}
+
+	if ok, _ := bucket.take(now.Add(time.Hour)); ok {
+		t.Error("bucket should not refill past its burst")
+	}
+}
+
+// Reads n messages, returning the ServerErrors with the rate limited code.
+func readRateLimited(t *testing.T, ws *websocket.Conn, n int) []ServerError {
+	t.Helper()
+
+	var limited []ServerError
+
+	for i := 0; i < n; i++ {
+		var serverErr ServerError
+
+		if err := ws.ReadJSON(&serverErr); err != nil {
+			t.Fatal(err)
+		}
+
+		if data, _ := serverErr.Data.(map[string]interface{}); data["code"] == ErrCodeRateLimited {
+			limited = append(limited, serverErr)
+		}
+	}
+
+	return limited
+}
+
+func TestConnectionRateLimit(t *testing.T) {
+	ws := dialTestServer(t, NewRealtimeServer(WithRateLimit(RateLimit{Rate: 0.001, Burst: 2})))
+
+	for _, ref := range []string{"1", "2", "3"} {
+		ws.WriteJSON(&ClientMessage{Message: Message{Type: ClientEvent, Channel: "missing"}, Event: "ping", Ref: ref})
+	}
+
+	limited := readRateLimited(t, ws, 3)
+
+	if len(limited) != 1 || limited[0].Ref != "3" {
+		t.Fatalf("expected the third message to be rate limited, got %+v", limited)
+	}
+
+	data := limited[0].Data.(map[string]interface{})
+
+	if data["scope"] != connectionLimit || data["retry_after_ms"].(float64) <= 0 {
+		t.Errorf("expected connection scope with retry after, got %+v", data)
+	}
+}
+
+func TestEventRateLimit(t *testing.T) {
+	rts := NewRealtimeServer()
+	cf := NewChannelFactory("room")
+
+	for _, event := range []string{"ping", "pong"} {
+		cf.HandleReply(event, func(ctx context.Context, e *Event) (interface{}, error) {
+			return nil, nil
+		})
+	}
+
+	cf.LimitEventRate("ping", RateLimit{Rate: 0.001, Burst: 1})
+	rts.RegisterChannelFactory(cf)
+
+	ws := dialTestServer(t, rts)
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: Subscribe, Channel: "room"}, Ref: "1"})
+
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, event := range []string{"ping", "ping", "pong"} {
+		ws.WriteJSON(&ClientMessage{Message: Message{Type: ClientEvent, Channel: "room"}, Event: event, Ref: event})
+	}
+
+	limited := readRateLimited(t, ws, 3)
+
+	if len(limited) != 1 || limited[0].Ref != "ping" {
+		t.Fatalf("expected one ping to be rate limited, got %+v", limited)
+	}
+
+	if data := limited[0].Data.(map[string]interface{}); data["scope"] != eventLimit || data["channel"] != "room" {
+		t.Errorf("expected event scope on room, got %+v", data)
+	}
+}
+
+func TestRateLimitDisconnect(t *testing.T) {
+	rts := NewRealtimeServer(
+		WithRateLimit(RateLimit{Rate: 0.001, Burst: 1}),
+		WithRateLimitDisconnect(RateLimitDisconnect{Violations: 2, Window: time.Minute}),
+	)
+	ws := dialTestServer(t, rts)
+
+	for i := 0; i < 3; i++ {
+		ws.WriteJSON(&ClientMessage{Message: Message{Type: ClientEvent, Channel: "missing"}, Event: "ping"})
+	}
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	expectClose(t, ws, websocket.ClosePolicyViolation)
+}
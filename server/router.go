@@ -0,0 +1,334 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrRouteConflict = errors.New("router: conflicting channel path")
+
+// router matches channel names to channel factories with a trie keyed on the
+// segments of their paths. At each segment static segments take precedence
+// over constrained params, then {name} params, then * and finally **.
+// Constrained params at the same segment must not overlap, so only distinct
+// types other than string may share one.
+type router struct {
+	root *routeNode
+}
+
+type routeNode struct {
+	static   map[string]*routeNode
+	params   []*routeNode
+	wildcard *routeNode
+	catchAll *routeNode
+
+	// set on every node but static ones. Param nodes are shared by every
+	// path with the same constraint at the same position, so the segment's
+	// name is only that of the first.
+	segment    pathSegment
+	paramIndex int
+
+	// set on nodes a path ends at, naming the params captured along it
+	factory   *ChannelFactory
+	paramKeys []string
+}
+
+func newRouter() *router {
+	return &router{root: newRouteNode()}
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{static: make(map[string]*routeNode)}
+}
+
+// Adds cf's path to the trie.
+func (r *router) insert(cf *ChannelFactory) error {
+	if cf.err != nil {
+		return cf.err
+	}
+
+	return r.insertPath(cf.DotPath, cf)
+}
+
+// Adds every variant of dp to the trie, leading to cf. Paths that would match
+// exactly the same channel names as an existing path, or whose constrained
+// params overlap those of an existing path at the same segment, are rejected
+// and leave the trie untouched.
+func (r *router) insertPath(dp *DotPath, cf *ChannelFactory) error {
+	variants := dp.variants()
+
+	// Variants of the same path may conflict with each other
+	scratch := newRouter()
+
+	for _, variant := range variants {
+		if err := scratch.insertVariant(dp, variant, cf); err != nil {
+			return err
+		}
+	}
+
+	for _, variant := range variants {
+		if err := r.check(dp, variant); err != nil {
+			return err
+		}
+	}
+
+	for _, variant := range variants {
+		if err := r.insertVariant(dp, variant, cf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reports the conflict inserting segments would cause without modifying the
+// trie.
+func (r *router) check(dp *DotPath, segments []pathSegment) error {
+	n := r.root
+
+	for _, segment := range segments {
+		var child *routeNode
+
+		switch segment.kind {
+		case staticSegment:
+			child = n.static[segment.value]
+		case paramSegment:
+			if err := n.checkParam(segment, dp.path); err != nil {
+				return err
+			}
+
+			child = n.param(segment)
+		case wildcardSegment:
+			child = n.wildcard
+		case catchAllSegment:
+			child = n.catchAll
+		}
+
+		// Nothing has been registered below here
+		if child == nil {
+			return nil
+		}
+
+		n = child
+	}
+
+	if n.factory != nil {
+		return fmt.Errorf("%w: %s is ambiguous with %s", ErrRouteConflict, dp.path, n.factory.path)
+	}
+
+	return nil
+}
+
+func (r *router) insertVariant(dp *DotPath, segments []pathSegment, cf *ChannelFactory) error {
+	n := r.root
+	var paramKeys []string
+
+	for _, segment := range segments {
+		var child *routeNode
+
+		switch segment.kind {
+		case staticSegment:
+			child = n.staticChild(segment.value)
+		case paramSegment:
+			if err := n.checkParam(segment, dp.path); err != nil {
+				return err
+			}
+
+			child = n.paramChild(segment)
+		case wildcardSegment:
+			if n.wildcard == nil {
+				n.wildcard = &routeNode{static: make(map[string]*routeNode), segment: segment}
+			}
+
+			child = n.wildcard
+		case catchAllSegment:
+			if n.catchAll == nil {
+				n.catchAll = &routeNode{static: make(map[string]*routeNode), segment: segment}
+			}
+
+			child = n.catchAll
+		}
+
+		switch segment.kind {
+		case paramSegment:
+			child.paramIndex = len(paramKeys)
+			paramKeys = append(paramKeys, segment.value)
+		case catchAllSegment:
+			child.paramIndex = len(paramKeys)
+			paramKeys = append(paramKeys, CatchAllParam)
+		}
+
+		n = child
+	}
+
+	if n.factory != nil {
+		return fmt.Errorf("%w: %s is ambiguous with %s", ErrRouteConflict, dp.path, n.factory.path)
+	}
+
+	n.factory = cf
+	n.paramKeys = paramKeys
+
+	return nil
+}
+
+func (n *routeNode) staticChild(value string) *routeNode {
+	child, ok := n.static[value]
+
+	if !ok {
+		child = newRouteNode()
+		n.static[value] = child
+	}
+
+	return child
+}
+
+// Returns the param child with segment's constraint, if any.
+func (n *routeNode) param(segment pathSegment) *routeNode {
+	for _, child := range n.params {
+		if child.segment.constraint == segment.constraint {
+			return child
+		}
+	}
+
+	return nil
+}
+
+// Reports a constrained param child that may match the same segments as
+// segment, as the one registered first would silently win.
+func (n *routeNode) checkParam(segment pathSegment, path string) error {
+	for _, child := range n.params {
+		if constraintsOverlap(child.segment.constraint, segment.constraint) {
+			return fmt.Errorf("%w: param {%s:%s} in %s overlaps {%s:%s}", ErrRouteConflict, segment.value, segment.constraint, path, child.segment.value, child.segment.constraint)
+		}
+	}
+
+	return nil
+}
+
+// Whether two distinct param constraints may match the same segment. The
+// unconstrained param may overlap any other, but constrained params take
+// precedence over it. Regular expressions are assumed to overlap anything.
+func constraintsOverlap(a string, b string) bool {
+	if a == b || a == "" || b == "" {
+		return false
+	}
+
+	_, aTyped := paramTypes[a]
+	_, bTyped := paramTypes[b]
+
+	return !aTyped || !bTyped || a == "string" || b == "string"
+}
+
+// Returns the param child with segment's constraint, adding it if needed.
+// Constrained params are kept ahead of the unconstrained one.
+func (n *routeNode) paramChild(segment pathSegment) *routeNode {
+	if child := n.param(segment); child != nil {
+		return child
+	}
+
+	child := &routeNode{static: make(map[string]*routeNode), segment: segment}
+
+	if last := len(n.params) - 1; segment.constraint != "" && last >= 0 && n.params[last].segment.constraint == "" {
+		n.params = append(n.params[:last], child, n.params[last])
+	} else {
+		n.params = append(n.params, child)
+	}
+
+	return child
+}
+
+// Finds the factory matching name. Matching itself does not allocate; params
+// are only allocated for paths that have them.
+func (r *router) lookup(name string) (*ChannelFactory, Params) {
+	return r.root.match(name, true)
+}
+
+// Matches the remainder of a channel name below n. more is false once every
+// segment has been consumed.
+func (n *routeNode) match(name string, more bool) (*ChannelFactory, Params) {
+	if !more {
+		return n.leaf()
+	}
+
+	segment, rest, more := name, "", false
+
+	if i := strings.Index(name, delimiter); i >= 0 {
+		segment, rest, more = name[:i], name[i+1:], true
+	}
+
+	if child, ok := n.static[segment]; ok {
+		if cf, params := child.match(rest, more); cf != nil {
+			return cf, params
+		}
+	}
+
+	for _, child := range n.params {
+		if !child.segment.matches(segment) {
+			continue
+		}
+
+		if cf, params := child.match(rest, more); cf != nil {
+			params[child.paramIndex].Value = segment
+			return cf, params
+		}
+	}
+
+	if n.wildcard != nil && segment != "" {
+		if cf, params := n.wildcard.match(rest, more); cf != nil {
+			return cf, params
+		}
+	}
+
+	if n.catchAll != nil && hasNoEmptySegments(name) {
+		if cf, params := n.catchAll.leaf(); cf != nil {
+			params[n.catchAll.paramIndex].Value = name
+			return cf, params
+		}
+	}
+
+	return nil, nil
+}
+
+func (n *routeNode) leaf() (*ChannelFactory, Params) {
+	if n.factory == nil {
+		return nil, nil
+	}
+
+	if len(n.paramKeys) == 0 {
+		return n.factory, emptyParams
+	}
+
+	params := make(Params, len(n.paramKeys))
+
+	for i, key := range n.paramKeys {
+		params[i].Key = key
+	}
+
+	return n.factory, params
+}
+
+func hasNoEmptySegments(name string) bool {
+	if name == "" || strings.HasPrefix(name, delimiter) || strings.HasSuffix(name, delimiter) {
+		return false
+	}
+
+	return !strings.Contains(name, delimiter+delimiter)
+}
+
+// Whether s is non-empty and matches \w+, without compiling a regexp.
+func isWord(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if !(c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestRouter(t *testing.T, paths ...string) *router {
+	t.Helper()
+
+	r := newRouter()
+
+	for _, path := range paths {
+		if err := r.insert(NewChannelFactory(path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return r
+}
+
+func TestRouterStaticPrecedence(t *testing.T) {
+	r := newTestRouter(t, "test.{id}.channel", "test.lobby.channel", "test.lobby.{other}")
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"test.lobby.channel", "test.lobby.channel"},
+		{"test.1.channel", "test.{id}.channel"},
+		{"test.lobby.chat", "test.lobby.{other}"},
+	}
+
+	for _, tt := range tests {
+		cf, _ := r.lookup(tt.name)
+
+		if cf == nil || cf.path != tt.path {
+			t.Errorf("%s should match %s, got %v", tt.name, tt.path, cf)
+		}
+	}
+
+	if cf, _ := r.lookup("test.lobby"); cf != nil {
+		t.Errorf("test.lobby should not match, got %s", cf.path)
+	}
+
+	if cf, _ := r.lookup("test.1.channel."); cf != nil {
+		t.Errorf("trailing delimiter should not match, got %s", cf.path)
+	}
+}
+
+func TestRouterBacktracksToParams(t *testing.T) {
+	r := newTestRouter(t, "a.b.c", "a.{x}.d")
+
+	cf, params := r.lookup("a.b.d")
+
+	if cf == nil || cf.path != "a.{x}.d" {
+		t.Fatalf("a.b.d should match a.{x}.d, got %v", cf)
+	}
+
+	if params.Param("x") != "b" {
+		t.Errorf("x should be b, got %s", params.Param("x"))
+	}
+}
+
+func TestRouterConflicts(t *testing.T) {
+	r := newTestRouter(t, "test.{id}.channel")
+
+	for _, path := range []string{"test.{id}.channel", "test.{name}.channel"} {
+		if err := r.insert(NewChannelFactory(path)); !errors.Is(err, ErrRouteConflict) {
+			t.Errorf("%s should conflict, got %v", path, err)
+		}
+	}
+}
+
+func TestRouterParamNamesPerPath(t *testing.T) {
+	r := newTestRouter(t, "orders.{id}", "orders.{orderId}.items", "test.{id}.channel", "test.{name}.other")
+
+	tests := []struct {
+		name  string
+		path  string
+		param string
+		value string
+	}{
+		{"orders.1", "orders.{id}", "id", "1"},
+		{"orders.2.items", "orders.{orderId}.items", "orderId", "2"},
+		{"test.3.other", "test.{name}.other", "name", "3"},
+	}
+
+	for _, tt := range tests {
+		cf, params := r.lookup(tt.name)
+
+		if cf == nil || cf.path != tt.path {
+			t.Errorf("%s should match %s, got %v", tt.name, tt.path, cf)
+			continue
+		}
+
+		if value, ok := params.Get(tt.param); !ok || value != tt.value || params.Len() != 1 {
+			t.Errorf("%s should capture %s=%s, got %v", tt.name, tt.param, tt.value, params)
+		}
+	}
+}
+
+func TestRouterStaticLookupDoesNotAllocate(t *testing.T) {
+	r := newTestRouter(t, "test.{id}.channel", "test.lobby.channel")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		r.lookup("test.lobby.channel")
+	})
+
+	if allocs != 0 {
+		t.Errorf("static lookup should not allocate, got %v", allocs)
+	}
+}
+
+func TestRouterRejectsOverlappingConstraints(t *testing.T) {
+	r := newTestRouter(t, "n.{a:int}", "n.{b:uuid}", "n.{c}")
+
+	for _, path := range []string{"n.{d:[0-9]+}", "n.{e:string}", "n.{f:[a-z]+}.x"} {
+		if err := r.insert(NewChannelFactory(path)); !errors.Is(err, ErrRouteConflict) {
+			t.Errorf("%s should conflict, got %v", path, err)
+		}
+	}
+
+	if err := r.insert(NewChannelFactory("n.{g:int}.x")); err != nil {
+		t.Errorf("params with the same constraint should share a segment, got %v", err)
+	}
+
+	if cf, _ := r.lookup("n.5"); cf == nil || cf.path != "n.{a:int}" {
+		t.Errorf("n.5 should match n.{a:int}, got %v", cf)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// BackpressurePolicy decides what happens when a connection's send queue is
+// full.
+type BackpressurePolicy int
+
+const (
+	// DropNewest discards the message being sent
+	DropNewest BackpressurePolicy = iota
+	// DropOldest discards the oldest queued message to make room
+	DropOldest
+	// DisconnectSlowConsumer closes the connection with the queue's close code
+	DisconnectSlowConsumer
+)
+
+type SendQueueConfig struct {
+	Size      int
+	Policy    BackpressurePolicy
+	CloseCode int
+}
+
+var DefaultSendQueueConfig = SendQueueConfig{
+	Size:      256,
+	Policy:    DropNewest,
+	CloseCode: websocket.CloseTryAgainLater,
+}
+
+var (
+	errSlowConsumer    = errors.New("send queue: slow consumer")
+	errSendQueueClosed = errors.New("send queue: closed")
+)
+
+// sendQueue is a bounded outbound queue drained by a connection's writer.
+// ready is signalled whenever messages are pushed.
+type sendQueue struct {
+	mu     sync.Mutex
+	msgs   []*encodedMessage
+	closed bool
+
+	size    int
+	policy  BackpressurePolicy
+	dropped uint64
+
+	ready chan struct{}
+}
+
+func newSendQueue(config SendQueueConfig) *sendQueue {
+	if config.Size <= 0 {
+		config.Size = DefaultSendQueueConfig.Size
+	}
+
+	return &sendQueue{
+		msgs:   make([]*encodedMessage, 0, config.Size),
+		size:   config.Size,
+		policy: config.Policy,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// Queues msg. When the queue is full, returns the message the policy
+// dropped, which is msg itself under DropNewest, or errSlowConsumer under
+// DisconnectSlowConsumer.
+func (q *sendQueue) push(msg *encodedMessage) (*encodedMessage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, errSendQueueClosed
+	}
+
+	var dropped *encodedMessage
+
+	if len(q.msgs) >= q.size {
+		atomic.AddUint64(&q.dropped, 1)
+
+		switch q.policy {
+		case DropNewest:
+			return msg, nil
+		case DisconnectSlowConsumer:
+			return nil, errSlowConsumer
+		case DropOldest:
+			dropped = q.msgs[0]
+			q.msgs[0] = nil
+			q.msgs = q.msgs[1:]
+		}
+	}
+
+	q.msgs = append(q.msgs, msg)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+
+	return dropped, nil
+}
+
+func (q *sendQueue) pop() (*encodedMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.msgs) == 0 {
+		return nil, false
+	}
+
+	msg := q.msgs[0]
+	q.msgs[0] = nil
+	q.msgs = q.msgs[1:]
+
+	return msg, true
+}
+
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.msgs)
+}
+
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.msgs = nil
+}
+
+func (q *sendQueue) droppedCount() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSendQueuePolicies(t *testing.T) {
+	tests := []struct {
+		policy  BackpressurePolicy
+		err     error
+		dropped string
+		first   string
+	}{
+		{DropNewest, nil, "3", "1"},
+		{DropOldest, nil, "1", "2"},
+		{DisconnectSlowConsumer, errSlowConsumer, "", "1"},
+	}
+
+	for _, tt := range tests {
+		q := newSendQueue(SendQueueConfig{Size: 2, Policy: tt.policy})
+
+		q.push(&encodedMessage{data: []byte("1")})
+		q.push(&encodedMessage{data: []byte("2")})
+
+		dropped, err := q.push(&encodedMessage{data: []byte("3")})
+
+		if err != tt.err {
+			t.Errorf("policy %d: expected %v, got %v", tt.policy, tt.err, err)
+		}
+
+		if dropped != nil && string(dropped.data) != tt.dropped || dropped == nil && tt.dropped != "" {
+			t.Errorf("policy %d: expected %q dropped, got %v", tt.policy, tt.dropped, dropped)
+		}
+
+		if q.droppedCount() != 1 {
+			t.Errorf("policy %d: expected 1 dropped, got %d", tt.policy, q.droppedCount())
+		}
+
+		if msg, _ := q.pop(); string(msg.data) != tt.first {
+			t.Errorf("policy %d: expected first message %s, got %s", tt.policy, tt.first, msg.data)
+		}
+	}
+}
+
+func TestSendQueueClosed(t *testing.T) {
+	q := newSendQueue(DefaultSendQueueConfig)
+	q.close()
+
+	if _, err := q.push(&encodedMessage{data: []byte("1")}); err != errSendQueueClosed {
+		t.Errorf("push to closed queue should fail, got %v", err)
+	}
+}
+
+func TestDropOldestCountsQueuedMessageAsSent(t *testing.T) {
+	rts := NewRealtimeServer()
+	conn := newTestConnection()
+	conn.server = rts
+	conn.send = newSendQueue(SendQueueConfig{Size: 1, Policy: DropOldest})
+
+	conn.enqueue(&encodedMessage{data: []byte("1"), event: "old"})
+	conn.enqueue(&encodedMessage{data: []byte("2"), event: "new"})
+
+	body := scrapeMetrics(rts)
+
+	for _, line := range []string{
+		`realtime_messages_sent_total{event="old"} 1`,
+		`realtime_messages_sent_total{event="new"} 1`,
+		"realtime_messages_dropped_total 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected metrics to contain %q", line)
+		}
+	}
+
+	if msg, _ := conn.send.pop(); string(msg.data) != "2" {
+		t.Errorf("expected the new message to stay queued, got %s", msg.data)
+	}
+}
@@ -1,10 +1,14 @@
 package server
 
 import (
-	"log"
+	"context"
+	"errors"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -18,50 +22,283 @@ type RealtimeServer struct {
 	Hub      *Hub
 	mu       sync.Mutex
 	upgrader *websocket.Upgrader
+
+	authenticator     Authenticator
+	patternAuthorizer PatternAuthorizer
+	sendQueue         SendQueueConfig
+	compression       CompressionConfig
+	codecs            []Codec
+
+	readLimit  int64
+	malformed  MalformedMessagePolicy
+	writeWait  time.Duration
+	pongWait   time.Duration
+	pingPeriod time.Duration
+	logger     Logger
+	tracer     Tracer
+	newID      func() uuid.UUID
+
+	rateLimit           RateLimit
+	rateLimitDisconnect RateLimitDisconnect
+
+	// dropped counts messages dropped across all connections' send queues
+	dropped uint64
+	metrics *metrics
+
+	connsMu      sync.Mutex
+	connections  ConnectionMap
+	shuttingDown bool
 }
 
-func NewRealtimeServer() *RealtimeServer {
-	return &RealtimeServer{
-		Hub: newHub(),
+var ErrServerShutdown = errors.New("rts: server shutting down")
+
+const shutdownReason = "server shutting down"
+
+func NewRealtimeServer(opts ...Option) *RealtimeServer {
+	s := &RealtimeServer{
+		Hub:         newHub(),
+		sendQueue:   DefaultSendQueueConfig,
+		compression: DefaultCompressionConfig,
+		codecs:      []Codec{JSONCodec, MsgpackCodec},
+		connections: make(ConnectionMap),
+		readLimit:   DefaultReadLimit,
+		writeWait:   defaultWriteWait,
+		pongWait:    defaultPongWait,
+		pingPeriod:  (defaultPongWait * 9) / 10,
+		logger:      defaultLogger,
+		tracer:      noopTracer{},
+		newID:       uuid.New,
 		upgrader: &websocket.Upgrader{
 			CheckOrigin: func(*http.Request) bool {
 				return true
 			},
+			Subprotocols: []string{JSONCodec.Name(), MsgpackCodec.Name()},
 		},
 	}
+
+	s.metrics = newMetrics(func() float64 {
+		return float64(s.DroppedMessages())
+	})
+	s.Hub.metrics = s.metrics
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.Hub.logger = s.logger
+	s.Hub.tracer = s.tracer
+
+	return s
+}
+
+// Returns the codec for the negotiated subprotocol.
+func (s *RealtimeServer) codec(subprotocol string) Codec {
+	for _, codec := range s.codecs {
+		if codec.Name() == subprotocol {
+			return codec
+		}
+	}
+
+	return s.codecs[0]
+}
+
+// DroppedMessages returns the number of messages dropped across all
+// connections because their send queue was full.
+func (s *RealtimeServer) DroppedMessages() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *RealtimeServer) authorizePattern(conn *Connection, pattern string) error {
+	if s.patternAuthorizer == nil {
+		return Reject(RejectForbidden, "Pattern subscriptions are not allowed")
+	}
+
+	return s.patternAuthorizer(conn, pattern)
+}
+
+func (s *RealtimeServer) authenticate(r *http.Request) (interface{}, error) {
+	if s.authenticator == nil {
+		return nil, nil
+	}
+
+	return s.authenticator.Authenticate(r)
 }
 
 func (s *RealtimeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	c, err := s.upgrader.Upgrade(w, r, nil)
+	if s.isShuttingDown() {
+		http.Error(w, ErrServerShutdown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	identity, err := s.authenticate(r)
 
 	if err != nil {
-		log.Print("[rts] upgrade:", err)
+		s.logger.Log(LevelWarn, "authentication rejected", errField(err))
+		writeAuthError(w, err)
 		return
 	}
 
-	log.Printf("[rts] Creating connection")
+	c, err := s.upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		s.logger.Log(LevelWarn, "upgrade failed", errField(err))
+		return
+	}
 
 	ctx := r.Context()
-	conn := newConnection(ctx, c, s)
+	conn := newConnection(ctx, c, s, identity)
+	c.SetCompressionLevel(conn.compression.Level)
+
+	if !s.addConnection(conn) {
+		conn.shutdown(websocket.CloseGoingAway, shutdownReason)
+	}
 
+	defer s.removeConnection(conn)
 	defer conn.closeConnection()
 
-	log.Printf("[rts] %s created", conn)
+	conn.log(LevelInfo, "connection opened", Field{Key: "codec", Value: conn.codec.Name()})
 
 	conn.start()
 }
 
+func (s *RealtimeServer) isShuttingDown() bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	return s.shuttingDown
+}
+
+// Tracks conn until it closes. Returns false when the server began shutting
+// down after conn was upgraded.
+func (s *RealtimeServer) addConnection(conn *Connection) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	if s.shuttingDown {
+		return false
+	}
+
+	s.connections[conn] = true
+	s.metrics.connectionOpened()
+
+	return true
+}
+
+func (s *RealtimeServer) removeConnection(conn *Connection) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	if s.connections[conn] {
+		delete(s.connections, conn)
+		s.metrics.connectionClosed()
+	}
+}
+
+// MetricsHandler serves the server's metrics in the Prometheus text format:
+// open connections and channels, subscribes and unsubscribes, messages
+// received and sent by event, dropped messages and handler latency and
+// errors.
+func (s *RealtimeServer) MetricsHandler() http.Handler {
+	return s.metrics
+}
+
+// Shutdown gracefully closes every connection. See ShutdownWithHint.
+func (s *RealtimeServer) Shutdown(ctx context.Context) error {
+	return s.ShutdownWithHint(ctx, "")
+}
+
+// ShutdownWithHint stops accepting upgrades, then flushes every connection's
+// send queue and closes it with a going away close frame whose reason is
+// hint, for example a reconnect delay. It returns once every connection has
+// closed and run its channels' Leave handlers, or ctx is done.
+func (s *RealtimeServer) ShutdownWithHint(ctx context.Context, hint string) error {
+	if hint == "" {
+		hint = shutdownReason
+	}
+
+	s.connsMu.Lock()
+	s.shuttingDown = true
+
+	conns := make([]*Connection, 0, len(s.connections))
+
+	for conn := range s.connections {
+		conns = append(conns, conn)
+	}
+	s.connsMu.Unlock()
+
+	s.logger.Log(LevelInfo, "shutting down", Field{Key: "connections", Value: len(conns)})
+
+	for _, conn := range conns {
+		conn.shutdown(websocket.CloseGoingAway, hint)
+	}
+
+	for _, conn := range conns {
+		select {
+		case <-conn.closed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Use adds middleware wrapping the handlers of every registered channel.
+func (s *RealtimeServer) Use(mw ...Middleware) {
+	s.Hub.use(mw...)
+}
+
+// Publication is a single event published with PublishBatch.
+type Publication struct {
+	Channel string
+	Event   string
+	Data    interface{}
+}
+
+// Publish emits event to every subscriber of channelName from outside a
+// handler. It returns whether the channel has subscribers on this node, and
+// ErrChannelNotFound when no registered channel matches channelName. data is
+// sent as is to connections on this node, so it must not be modified once
+// published.
+func (s *RealtimeServer) Publish(channelName string, event string, data interface{}) (bool, error) {
+	return s.Hub.publish(channelName, event, data)
+}
+
+// PublishBatch publishes each Publication in order, stopping at the first
+// error. It returns how many publications reached a channel with subscribers
+// on this node.
+func (s *RealtimeServer) PublishBatch(pubs []Publication) (int, error) {
+	delivered := 0
+
+	for _, pub := range pubs {
+		ok, err := s.Hub.publish(pub.Channel, pub.Event, pub.Data)
+
+		if err != nil {
+			return delivered, err
+		}
+
+		if ok {
+			delivered++
+		}
+	}
+
+	return delivered, nil
+}
+
 type ChannelHandler interface {
 	Start(*ChannelFactory)
 }
 
-func (s *RealtimeServer) RegisterChannel(path string, ch ChannelHandler) {
+// RegisterChannel starts ch with a new factory for path. It returns
+// ErrRouteConflict when path is ambiguous with an already registered path.
+func (s *RealtimeServer) RegisterChannel(path string, ch ChannelHandler) error {
 	factory := NewChannelFactory(path)
 
 	ch.Start(factory)
-	s.Hub.registerChannelFactory(factory)
+
+	return s.Hub.registerChannelFactory(factory)
 }
 
-func (s *RealtimeServer) RegisterChannelFactory(ch *ChannelFactory) {
-	s.Hub.registerChannelFactory(ch)
+func (s *RealtimeServer) RegisterChannelFactory(ch *ChannelFactory) error {
+	return s.Hub.registerChannelFactory(ch)
 }
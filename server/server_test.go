@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestShutdownClosesConnections(t *testing.T) {
+	rts := NewRealtimeServer()
+	cf := NewChannelFactory("room")
+
+	left := make(chan bool, 1)
+	cf.Leave(func(ctx context.Context, e *Event) error {
+		left <- true
+		return nil
+	})
+	rts.RegisterChannelFactory(cf)
+
+	srv := httptest.NewServer(rts)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ws.Close()
+
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: Subscribe, Channel: "room"}, Ref: "1"})
+
+	// Wait for the subscription to be confirmed
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := rts.ShutdownWithHint(ctx, "reconnect:5s"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-left:
+	default:
+		t.Error("Leave handler should run before Shutdown returns")
+	}
+
+	_, _, err = ws.ReadMessage()
+
+	if closeErr, ok := err.(*websocket.CloseError); !ok || closeErr.Code != websocket.CloseGoingAway || closeErr.Text != "reconnect:5s" {
+		t.Errorf("expected going away close frame, got %v", err)
+	}
+
+	resp, err := http.Get(srv.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected %d after shutdown, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
+
+func TestClosedConnectionDoesNotJoin(t *testing.T) {
+	joining := make(chan bool)
+	release := make(chan bool)
+
+	block := func() {
+		joining <- true
+		<-release
+	}
+
+	rts := NewRealtimeServer(WithPatternAuthorizer(func(conn *Connection, pattern string) error {
+		block()
+		return nil
+	}))
+	cf := NewChannelFactory("room.{id}")
+	cf.TrackPresence()
+	cf.BeforeJoin(func(ctx context.Context, e *Event) error {
+		block()
+		return nil
+	})
+	rts.RegisterChannelFactory(cf)
+
+	for _, channel := range []string{"room.1", "room.*"} {
+		ws := dialTestServer(t, rts)
+		ws.WriteJSON(&ClientMessage{Message: Message{Type: Subscribe, Channel: channel}, Ref: "1"})
+
+		<-joining
+		ws.Close()
+
+		for len(rts.Connections()) > 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		release <- true
+	}
+
+	// Gives the released subscriptions time to complete
+	time.Sleep(50 * time.Millisecond)
+
+	if channels := rts.Channels(); len(channels) != 0 {
+		t.Errorf("a connection closed while joining should not stay subscribed, got %+v", channels)
+	}
+
+	rts.Hub.patternsMu.RLock()
+	defer rts.Hub.patternsMu.RUnlock()
+
+	if len(rts.Hub.patterns) != 0 {
+		t.Errorf("a connection closed while subscribing should not keep its patterns, got %v", rts.Hub.patterns)
+	}
+}
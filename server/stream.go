@@ -0,0 +1,172 @@
+package server
+
+import (
+	"container/list"
+	"time"
+)
+
+// DefaultHistoryLimit is the default number of closed channels whose history
+// each node keeps for replay.
+const DefaultHistoryLimit = 10000
+
+// How often parked streams are checked for history that has aged out.
+const historySweepInterval = time.Minute
+
+// stream is a channel name's broker subscription on this node. It records
+// every message into the channel's history and fans it out while the channel
+// is open. When a channel whose history retains messages closes, its stream
+// is parked rather than dropped, so messages published while nobody on this
+// node is subscribed can still be replayed with since.
+type stream struct {
+	name    string
+	factory *ChannelFactory
+	history *history
+
+	// channel is the open channel, nil while parked. Guarded by the hub's mu.
+	channel  *Channel
+	parkedAt time.Time
+	parked   *list.Element
+
+	unsubscribe func()
+}
+
+// Returns the handler delivering broker messages to s.
+func (h *Hub) deliverStream(s *stream) BrokerHandler {
+	return func(msg *BrokerMessage) {
+		h.mu.RLock()
+		channel := s.channel
+		h.mu.RUnlock()
+
+		if channel != nil {
+			channel.deliver(msg)
+			return
+		}
+
+		s.record(msg)
+	}
+}
+
+// Records msg into a parked stream's history.
+func (s *stream) record(msg *BrokerMessage) {
+	s.history.mu.Lock()
+	defer s.history.mu.Unlock()
+
+	prepared := s.history.record(&ServerMessage{
+		Message: Message{
+			Channel: s.name,
+			Type:    ServerEvent,
+		},
+		Event: msg.Event,
+		Data:  msg.payload(),
+		Seq:   msg.Seq,
+	})
+	prepared.uncompressed = s.factory.uncompressed
+}
+
+// Returns the stream for channelName, subscribing to the broker when there is
+// none. The broker may block on the network while its handlers wait on h.mu,
+// so h.mu is released while subscribing and callers must recheck anything
+// else they read under it. Callers must hold h.mu.
+func (h *Hub) stream(channelName string, cf *ChannelFactory) (*stream, error) {
+	if s, ok := h.streams[channelName]; ok {
+		return s, nil
+	}
+
+	s := &stream{
+		name:    channelName,
+		factory: cf,
+		history: cf.newHistory(),
+	}
+
+	h.mu.Unlock()
+	unsubscribe, err := h.broker.Subscribe(channelName, h.deliverStream(s))
+	h.mu.Lock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.unsubscribe = unsubscribe
+
+	// Another caller may have subscribed while h.mu was released
+	if existing, ok := h.streams[channelName]; ok {
+		h.released = append(h.released, unsubscribe)
+		return existing, nil
+	}
+
+	h.streams[channelName] = s
+
+	return s, nil
+}
+
+// Parks s once it has no open channel, or drops it when its history retains
+// nothing. Callers must hold h.mu.
+func (h *Hub) park(s *stream, now time.Time) {
+	s.channel = nil
+
+	if !s.history.retains() {
+		h.dropStream(s)
+		return
+	}
+
+	s.parkedAt = now
+	s.parked = h.parked.PushBack(s)
+	h.sweep(now)
+}
+
+// Stops counting s as parked once its channel reopens. Callers must hold h.mu.
+func (h *Hub) unpark(s *stream) {
+	if s.parked != nil {
+		h.parked.Remove(s.parked)
+		s.parked = nil
+	}
+}
+
+// Drops s, unsubscribing it from the broker once h.mu is released. Callers
+// must hold h.mu.
+func (h *Hub) dropStream(s *stream) {
+	h.unpark(s)
+	h.released = append(h.released, s.unsubscribe)
+	delete(h.streams, s.name)
+}
+
+// Unlocks h.mu, then unsubscribes the streams released while it was held.
+func (h *Hub) unlock() {
+	released := h.released
+	h.released = nil
+	h.mu.Unlock()
+
+	for _, unsubscribe := range released {
+		unsubscribe()
+	}
+}
+
+// Drops parked streams whose history has aged out, at most once every
+// historySweepInterval, then the longest parked beyond the hub's history
+// limit. Callers must hold h.mu.
+func (h *Hub) sweep(now time.Time) {
+	if now.Sub(h.sweptAt) >= historySweepInterval {
+		h.sweptAt = now
+
+		for e := h.parked.Front(); e != nil; {
+			next := e.Next()
+
+			if s := e.Value.(*stream); s.expired(now) {
+				h.dropStream(s)
+			}
+
+			e = next
+		}
+	}
+
+	for h.parked.Len() > h.historyLimit {
+		h.dropStream(h.parked.Front().Value.(*stream))
+	}
+}
+
+// Whether a parked stream's history has aged out. Streams parked for less
+// than the max age are kept even when empty, as they were parked to record
+// messages. Histories limited only by size never expire.
+func (s *stream) expired(now time.Time) bool {
+	return s.history.maxAge > 0 && now.Sub(s.parkedAt) > s.history.maxAge && s.history.expired()
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		traceParent string
+		valid       bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if _, valid := ParseTraceParent(tt.traceParent); valid != tt.valid {
+			t.Errorf("%q: expected valid %t", tt.traceParent, tt.valid)
+		}
+	}
+}
+
+func findSpan(spans []RecordedSpan, name string, attr string, value interface{}) (RecordedSpan, bool) {
+	for _, span := range spans {
+		if span.Name == name && span.Attributes[attr] == value {
+			return span, true
+		}
+	}
+
+	return RecordedSpan{}, false
+}
+
+func TestTracing(t *testing.T) {
+	tracer := NewRecordingTracer()
+	rts := NewRealtimeServer(WithTracer(tracer))
+	cf := NewChannelFactory("room.{id}")
+
+	handlerSpans := make(chan SpanContext, 1)
+
+	cf.Authorize(func(ctx context.Context, e *Event) error {
+		return nil
+	})
+	cf.HandleReply("ping", func(ctx context.Context, e *Event) (interface{}, error) {
+		handlerSpans <- SpanFromContext(ctx).SpanContext()
+		return "pong", nil
+	})
+	rts.RegisterChannelFactory(cf)
+
+	ws := dialTestServer(t, rts)
+
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	meta := map[string]string{TraceParentKey: traceParent}
+
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: Subscribe, Channel: "room.1"}, Ref: "1", Metadata: meta})
+
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	ws.WriteJSON(&ClientMessage{Message: Message{Type: ClientEvent, Channel: "room.1"}, Event: "ping", Ref: "2"})
+
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	handlerSpan := <-handlerSpans
+
+	// Root spans end once their handlers return
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		if _, ok := findSpan(tracer.Spans(), "realtime.ClientEvent", "event", "ping"); ok {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected ClientEvent span to end")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	spans := tracer.Spans()
+
+	subscribe, _ := findSpan(spans, "realtime.Subscribe", "channel", "room.1")
+
+	if subscribe.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || subscribe.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("Subscribe span should continue the client's trace, got %+v", subscribe)
+	}
+
+	for _, name := range []string{"realtime.hub.lookup", "realtime.handler"} {
+		found := false
+
+		for _, span := range spans {
+			if span.Name == name && span.ParentID == subscribe.SpanID {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("expected %s span under Subscribe", name)
+		}
+	}
+
+	authorize, _ := findSpan(spans, "realtime.handler", "handler", "Authorize")
+
+	if authorize.ParentID != subscribe.SpanID {
+		t.Errorf("Authorize span should be a child of Subscribe, got %+v", authorize)
+	}
+
+	clientEvent, _ := findSpan(spans, "realtime.ClientEvent", "event", "ping")
+	ping, _ := findSpan(spans, "realtime.handler", "handler", "ping")
+
+	if clientEvent.TraceID == subscribe.TraceID {
+		t.Error("ClientEvent without a traceparent should start a new trace")
+	}
+
+	if ping.ParentID != clientEvent.SpanID || ping.SpanID != handlerSpan.SpanID {
+		t.Errorf("handler should receive its span, a child of ClientEvent, got %+v", ping)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"reflect"
+)
+
+const (
+	ErrCodeInvalidPayload   = "invalid_payload"
+	ErrCodeValidationFailed = "validation_failed"
+)
+
+// Validator is implemented by typed payloads that check themselves after
+// decoding.
+type Validator interface {
+	Validate() error
+}
+
+type TypedEventHandler[T any] func(context.Context, *Event, T) error
+
+// HandleTyped registers a handler whose payload is decoded into T. Payloads
+// that fail to decode, or whose T implements Validator and fails validation,
+// are answered with a ServerError and never reach the handler.
+func HandleTyped[T any](cf *ChannelFactory, event string, handler TypedEventHandler[T]) {
+	if handler == nil {
+		panic("cf: nil handler")
+	}
+
+	cf.Handle(event, func(ctx context.Context, e *Event) error {
+		payload, err := decodePayload[T](e)
+
+		if err != nil {
+			return err
+		}
+
+		return handler(ctx, e, payload)
+	})
+}
+
+func decodePayload[T any](e *Event) (T, error) {
+	var payload T
+
+	if err := e.Data(&payload); err != nil {
+		return payload, NewServerError("Invalid payload", ServerErrorFields{
+			"channel": e.Channel.Name,
+			"event":   e.Name(),
+			"code":    ErrCodeInvalidPayload,
+			"reason":  err.Error(),
+		})
+	}
+
+	var validator Validator
+
+	switch v := any(&payload).(type) {
+	case Validator:
+		validator = v
+	default:
+		validator, _ = any(payload).(Validator)
+	}
+
+	if validator == nil {
+		return payload, nil
+	}
+
+	// A null payload decodes to a nil pointer T, which cannot validate itself
+	if v := reflect.ValueOf(payload); v.Kind() == reflect.Pointer && v.IsNil() {
+		return payload, NewServerError("Invalid payload", ServerErrorFields{
+			"channel": e.Channel.Name,
+			"event":   e.Name(),
+			"code":    ErrCodeInvalidPayload,
+			"reason":  "payload is required",
+		})
+	}
+
+	if err := validator.Validate(); err != nil {
+		return payload, NewServerError("Validation failed", ServerErrorFields{
+			"channel": e.Channel.Name,
+			"event":   e.Name(),
+			"code":    ErrCodeValidationFailed,
+			"reason":  err.Error(),
+		})
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type typedPayload struct {
+	Name string `json:"name"`
+}
+
+func (p typedPayload) Validate() error {
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+
+	return nil
+}
+
+func TestHandleTyped(t *testing.T) {
+	hub := newHub()
+	cf := NewChannelFactory("room")
+
+	var received []string
+
+	HandleTyped(cf, "greet", func(ctx context.Context, e *Event, p typedPayload) error {
+		received = append(received, p.Name)
+		return nil
+	})
+	hub.registerChannelFactory(cf)
+
+	channel, _ := hub.findOrOpenChannel("room")
+	conn := newTestConnection()
+
+	channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+		Message: Message{Type: Subscribe, Channel: "room"},
+	}))
+
+	tests := []struct {
+		data string
+		code string
+	}{
+		{`{"name":"cole"}`, ""},
+		{`"not an object"`, ErrCodeInvalidPayload},
+		{`{"name":""}`, ErrCodeValidationFailed},
+	}
+
+	for _, tt := range tests {
+		channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+			Message: Message{Type: ClientEvent, Channel: "room"},
+			Event:   "greet",
+			RawData: json.RawMessage(tt.data),
+		}))
+
+		if tt.code == "" {
+			continue
+		}
+
+		var serverErr ServerError
+		json.Unmarshal(nextMessage(t, conn), &serverErr)
+
+		if fields, _ := serverErr.Data.(map[string]interface{}); fields["code"] != tt.code {
+			t.Errorf("%s should fail with %s, got %#v", tt.data, tt.code, serverErr)
+		}
+	}
+
+	if len(received) != 1 || received[0] != "cole" {
+		t.Errorf("handler should only receive valid payloads, got %v", received)
+	}
+}
+
+type pointerPayload struct {
+	Name string `json:"name"`
+}
+
+func (p *pointerPayload) Validate() error {
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+
+	return nil
+}
+
+func TestHandleTypedNullPointerPayload(t *testing.T) {
+	hub := newHub()
+	cf := NewChannelFactory("room")
+
+	HandleTyped(cf, "greet", func(ctx context.Context, e *Event, p *pointerPayload) error {
+		t.Error("handler should not receive a null payload")
+		return nil
+	})
+	hub.registerChannelFactory(cf)
+
+	channel, _ := hub.findOrOpenChannel("room")
+	conn := newTestConnection()
+
+	channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+		Message: Message{Type: Subscribe, Channel: "room"},
+	}))
+
+	channel.handleEvent(context.Background(), NewEvent(channel, conn, &ClientMessage{
+		Message: Message{Type: ClientEvent, Channel: "room"},
+		Event:   "greet",
+		RawData: json.RawMessage(`null`),
+	}))
+
+	var serverErr ServerError
+	json.Unmarshal(nextMessage(t, conn), &serverErr)
+
+	if fields, _ := serverErr.Data.(map[string]interface{}); fields["code"] != ErrCodeInvalidPayload {
+		t.Errorf("null payload should fail with %s, got %#v", ErrCodeInvalidPayload, serverErr)
+	}
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"context"
+	"errors"
 	"log"
 
 	"github.com/colevoss/awesome-go-realtime/server"
@@ -13,11 +14,12 @@ type Test struct {
 func (t *Test) Start(factory *server.ChannelFactory) {
 	log.Printf("test: starting test service")
 
+	factory.TrackPresence()
 	factory.BeforeJoin(t.TestBeforeJoin)
 	factory.Join(t.TestJoin)
 	factory.Leave(t.Leave)
-	factory.Handle("test", t.TestHandler)
-	factory.Handle("other-test", t.OtherTestHandler)
+	server.HandleTyped(factory, "test", t.TestHandler)
+	server.HandleTyped(factory, "other-test", t.OtherTestHandler)
 }
 
 type TestData struct {
@@ -26,11 +28,15 @@ type TestData struct {
 	Age  int32  `json:"age"`
 }
 
-func (t *Test) TestHandler(ctx context.Context, event *server.Event) error {
-	var testData TestData
+func (td TestData) Validate() error {
+	if td.Id == "" {
+		return errors.New("id is required")
+	}
 
-	event.Data(&testData)
+	return nil
+}
 
+func (t *Test) TestHandler(ctx context.Context, event *server.Event, testData TestData) error {
 	log.Printf("Test event for chann: %s: %v", event.Channel.Name, event.Param("id"))
 	log.Printf("My Test %#v", testData)
 
@@ -59,29 +65,16 @@ func (t *Test) TestJoin(ctx context.Context, event *server.Event) error {
 		"status": "joined",
 	})
 
-	event.Broadcast("joined", map[string]interface{}{
-		"id": event.Conn.Id,
-	})
-
 	return nil
 }
 
 func (t *Test) Leave(ctx context.Context, event *server.Event) error {
 	log.Printf("test: Conn leaving %s", event.Conn)
 
-	event.Broadcast("person-left", map[string]interface{}{
-		"id":            event.Conn.Id,
-		"what-happened": "they left",
-	})
-
 	return nil
 }
 
-func (t *Test) OtherTestHandler(ctx context.Context, event *server.Event) error {
-	var testData TestData
-
-	event.Data(&testData)
-
+func (t *Test) OtherTestHandler(ctx context.Context, event *server.Event, testData TestData) error {
 	log.Printf("OTHER: %s: %v", event.Channel.Name, event.Param("id"))
 	log.Printf("My Test %#v", testData)
 